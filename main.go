@@ -1,12 +1,21 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"sync"
-
-	"github.com/lucas-clemente/quic-go/http3"
-
+	lg "log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"server/src/api"
+	"server/src/certs"
 	"server/src/config"
+	"server/src/listen"
 	"server/src/log"
 	"server/src/settings"
 	"server/src/srv"
@@ -24,53 +33,93 @@ func main() {
 	src.DBInit()
 
 	srv.LoadSites()
+	if err := srv.LoadProxies(); err != nil {
+		log.Err(err, "Error loading proxies")
+		panic(err)
+	}
+	if err := srv.LoadGateways(); err != nil {
+		log.Err(err, "Error loading FastCGI gateways")
+		panic(err)
+	}
 
-	serv := srv.CreateServe()
+	if err := certs.Load(); err != nil {
+		log.Err(err, "Error loading certificate")
+		panic(err)
+	}
+	go certs.Watch()
+	if err := certs.LoadAcme(); err != nil {
+		log.Err(err, "Error loading ACME")
+		panic(err)
+	}
 
-	webServer3 := &http3.Server{
-		Addr:    "localhost:" + fmt.Sprintf("%d", config.GetConfig().Port),
-		Handler: serv,
+	if err := listen.Start(srv.CreateServe()); err != nil {
+		log.Err(err, "Error starting listeners")
+		panic(err)
 	}
 
-	wg := sync.WaitGroup{}
+	go certs.ObtainAcme()
+
+	var APIServer *http.Server
+	if config.GetConfig().ApiHost == "" || config.GetConfig().ApiToken == "" {
+		log.Log("Api disabled, it needs an ApiHost and an ApiToken")
+	} else {
+		APIServer = &http.Server{
+			Addr:      net.JoinHostPort(config.GetConfig().ApiHost, fmt.Sprintf("%d", config.GetConfig().ApiPort)),
+			Handler:   api.CreateServe(),
+			TLSConfig: certs.TLSConfig("h2", "http/1.1"),
+			ErrorLog:  lg.New(&log.LogWriter{}, "", 0),
+		}
+		go startAPI(APIServer)
+	}
 
-	wg.Add(1)
-	go func() {
-		startWebServer(webServer3)
-		wg.Done()
-	}()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	for sig := <-signals; sig == syscall.SIGHUP; sig = <-signals {
+		log.Log("Received", sig, "reloading certificate")
+		certs.Reload()
+	}
+	log.Log("Received stop signal, shutting down")
 
-	// http.HandleFunc("/", graph.GetPlayground)
-	// http.Handle("/query", handler.NewDefaultServer(gen.NewExecutableSchema(gen.Config{Resolvers: graph.GenResolver()})))
-	// APIServer := &http.Server{Addr: ":" + fmt.Sprintf("%d", config.GetConfig().ApiPort), Handler: http.DefaultServeMux}
-	// APIServer.ErrorLog = lg.New(&log.LogWriter{}, "", 0)
-	//
-	// startAPI(APIServer)
+	shutdown(APIServer)
 
-	wg.Wait()
+	listen.Wait()
+	log.Log("Server stopped")
 }
 
-func startWebServer(webServer *http3.Server) {
-	// blocks if success
-	log.Log(fmt.Sprintf("ListenAndServe Webserver HTTP/3 with TLS started on https://%s", webServer.Addr))
-	// err := webServer.ListenAndServeTLS(config.CertsFile, config.KeyFile)
-	err := http3.ListenAndServe(webServer.Addr, config.CertsFile, config.KeyFile, webServer.Handler)
+// shutdown stops accepting new connections, waits for running requests
+// and pending access logs and closes the DB Connection afterwards
+//
+// waits at most config.ShutdownTimeout, then closes anyway
+func shutdown(APIServer *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.GetConfig().ShutdownTimeout)*time.Second)
+	defer cancel()
+
+	tcpDone := listen.Shutdown(ctx)
+	if APIServer != nil {
+		if err := APIServer.Shutdown(ctx); err != nil {
+			log.Err(err, "Error shutting down Api")
+		}
+	}
 
-	if err != nil {
-		log.Err(err, "Error starting webServer")
-		panic(err)
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Err(err, "Error draining requests")
 	}
+
+	// http3 has no graceful shutdown, so it gets closed after all requests are done
+	listen.Close()
+	<-tcpDone
+
+	src.DBClose()
 }
 
-/*
 func startAPI(api *http.Server) {
 	// blocks if success
-	log.Log(fmt.Sprintf("ListenAndServe API with TLS started on localhost%s", api.Addr))
-	err := api.ListenAndServeTLS(config.CertsFile, config.KeyFile)
+	log.Log(fmt.Sprintf("ListenAndServe API with TLS started on https://%s", api.Addr))
+	// certificate is provided by TLSConfig
+	err := api.ListenAndServeTLS("", "")
 
-	if err != nil {
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Err(err, "Error starting Api")
 		panic(err)
 	}
 }
-*/
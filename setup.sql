@@ -5,15 +5,20 @@ use server;
 
 create table access
 (
-    id             timeuuid primary key,
-    code           smallint,
-    duration       int,
-    error          text,
-    method         text,
-    searchduration int,
-    uri            text,
-    writeerr       text,
-    encoding       text,
+    id              timeuuid primary key,
+    code            smallint,
+    duration        int,
+    error           text,
+    method          text,
+    searchduration  int,
+    uri             text,
+    writeerr        text,
+    encoding        text,
+    redirect        text,
+    requestid       text,
+    upstream        text,
+    upstreamlatency int,
+    username        text,
 )
     with caching = {'keys': 'ALL', 'rows_per_partition': 'ALL'}
      and compaction = {'class': 'SizeTieredCompactionStrategy'}
@@ -34,6 +39,17 @@ create table apiaccess
      and dclocal_read_repair_chance = 0
      and speculative_retry = '99.0PERCENTILE';
 
+create table settings
+(
+    name  text primary key,
+    value text
+)
+    with caching = {'keys': 'ALL', 'rows_per_partition': 'ALL'}
+     and compaction = {'class': 'SizeTieredCompactionStrategy'}
+     and compression = {'sstable_compression': 'org.apache.cassandra.io.compress.LZ4Compressor'}
+     and dclocal_read_repair_chance = 0
+     and speculative_retry = '99.0PERCENTILE';
+
 create table mime
 (
     "index"   int primary key,
@@ -58,3 +74,138 @@ create table server.forbidden
      and dclocal_read_repair_chance = 0
      and speculative_retry = '99.0PERCENTILE';
 
+create table server.acme
+(
+    name text primary key,
+    data blob
+)
+    with caching = {'keys': 'ALL', 'rows_per_partition': 'ALL'}
+     and compaction = {'class': 'SizeTieredCompactionStrategy'}
+     and compression = {'sstable_compression': 'org.apache.cassandra.io.compress.LZ4Compressor'}
+     and dclocal_read_repair_chance = 0
+     and speculative_retry = '99.0PERCENTILE';
+
+create table server.methods
+(
+    "index" int primary key,
+    path    text,
+    methods list<text>
+)
+    with caching = {'keys': 'ALL', 'rows_per_partition': 'ALL'}
+     and compaction = {'class': 'SizeTieredCompactionStrategy'}
+     and compression = {'sstable_compression': 'org.apache.cassandra.io.compress.LZ4Compressor'}
+     and dclocal_read_repair_chance = 0
+     and speculative_retry = '99.0PERCENTILE';
+
+create table server.cache_rules
+(
+    "index"                int primary key,
+    type                   text,
+    data                   text,
+    max_age                int,
+    stale_while_revalidate int,
+    immutable              boolean,
+    no_store               boolean,
+    extra                  text
+)
+    with caching = {'keys': 'ALL', 'rows_per_partition': 'ALL'}
+     and compaction = {'class': 'SizeTieredCompactionStrategy'}
+     and compression = {'sstable_compression': 'org.apache.cassandra.io.compress.LZ4Compressor'}
+     and dclocal_read_repair_chance = 0
+     and speculative_retry = '99.0PERCENTILE';
+
+create table server.autoindex
+(
+    "index" int primary key,
+    path    text
+)
+    with caching = {'keys': 'ALL', 'rows_per_partition': 'ALL'}
+     and compaction = {'class': 'SizeTieredCompactionStrategy'}
+     and compression = {'sstable_compression': 'org.apache.cassandra.io.compress.LZ4Compressor'}
+     and dclocal_read_repair_chance = 0
+     and speculative_retry = '99.0PERCENTILE';
+
+create table server.redirects
+(
+    "index" int primary key,
+    type    text,
+    data    text,
+    target  text,
+    code    int
+)
+    with caching = {'keys': 'ALL', 'rows_per_partition': 'ALL'}
+     and compaction = {'class': 'SizeTieredCompactionStrategy'}
+     and compression = {'sstable_compression': 'org.apache.cassandra.io.compress.LZ4Compressor'}
+     and dclocal_read_repair_chance = 0
+     and speculative_retry = '99.0PERCENTILE';
+
+create table server.header_rules
+(
+    "index"  int primary key,
+    type     text,
+    data     text,
+    mimetype text,
+    host     text,
+    action   text,
+    name     text,
+    value    text
+)
+    with caching = {'keys': 'ALL', 'rows_per_partition': 'ALL'}
+     and compaction = {'class': 'SizeTieredCompactionStrategy'}
+     and compression = {'sstable_compression': 'org.apache.cassandra.io.compress.LZ4Compressor'}
+     and dclocal_read_repair_chance = 0
+     and speculative_retry = '99.0PERCENTILE';
+
+create table server.cors
+(
+    "index"     int primary key,
+    path        text,
+    origins     list<text>,
+    methods     list<text>,
+    headers     list<text>,
+    credentials boolean,
+    max_age     int
+)
+    with caching = {'keys': 'ALL', 'rows_per_partition': 'ALL'}
+     and compaction = {'class': 'SizeTieredCompactionStrategy'}
+     and compression = {'sstable_compression': 'org.apache.cassandra.io.compress.LZ4Compressor'}
+     and dclocal_read_repair_chance = 0
+     and speculative_retry = '99.0PERCENTILE';
+
+create table server.spa_fallbacks
+(
+    "index"  int primary key,
+    path     text,
+    document text
+)
+    with caching = {'keys': 'ALL', 'rows_per_partition': 'ALL'}
+     and compaction = {'class': 'SizeTieredCompactionStrategy'}
+     and compression = {'sstable_compression': 'org.apache.cassandra.io.compress.LZ4Compressor'}
+     and dclocal_read_repair_chance = 0
+     and speculative_retry = '99.0PERCENTILE';
+
+create table server.auth_realms
+(
+    "index" int primary key,
+    path    text,
+    realm   text
+)
+    with caching = {'keys': 'ALL', 'rows_per_partition': 'ALL'}
+     and compaction = {'class': 'SizeTieredCompactionStrategy'}
+     and compression = {'sstable_compression': 'org.apache.cassandra.io.compress.LZ4Compressor'}
+     and dclocal_read_repair_chance = 0
+     and speculative_retry = '99.0PERCENTILE';
+
+create table server.auth_users
+(
+    realm text,
+    name  text,
+    hash  text,
+    primary key (realm, name)
+)
+    with caching = {'keys': 'ALL', 'rows_per_partition': 'ALL'}
+     and compaction = {'class': 'SizeTieredCompactionStrategy'}
+     and compression = {'sstable_compression': 'org.apache.cassandra.io.compress.LZ4Compressor'}
+     and dclocal_read_repair_chance = 0
+     and speculative_retry = '99.0PERCENTILE';
+
@@ -0,0 +1,204 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"server/src/auth"
+	"server/src/config"
+	"server/src/log"
+	"server/src/settings"
+	"server/src/srv"
+)
+
+// maximum size of request bodies
+const maxBodySize = 1 << 20
+
+// handlerFunc handles an api request, returns the status code and
+// the value to send as JSON, or an error which gets sent instead
+type handlerFunc func(r *http.Request) (int, any, error)
+
+// CreateServe
+//
+// Registers the routes of the api, every request needs
+// the config.ApiToken as "Authorization: Bearer <token>"
+//
+//	GET /settings         all settings by name
+//	GET /settings/{name}  value of a setting
+//	PUT /settings/{name}  store a new value of a setting
+//
+//	GET    /users                 all users of all realms
+//	GET    /users/{realm}         users of a realm
+//	GET    /users/{realm}/{name}  a user
+//	PUT    /users/{realm}/{name}  create a user or change its password
+//	DELETE /users/{realm}/{name}  delete a user
+func CreateServe() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/settings", handle(getSettings))
+	mux.Handle("/settings/", handle(setting))
+	mux.Handle("/users", handle(users))
+	mux.Handle("/users/", handle(users))
+	return mux
+}
+
+// handle writes the result of fun as JSON and logs the api access
+func handle(fun handlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		var code int
+		var value any
+		var err error
+		if authorized(r) {
+			code, value, err = fun(r)
+		} else {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			code, err = http.StatusUnauthorized, errors.New("missing or invalid api token")
+		}
+		if err != nil {
+			log.Err(err, fmt.Sprintf("Error handling api request %s %s", r.Method, r.URL.Path))
+			value = map[string]string{"error": err.Error()}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		if value != nil {
+			if er := json.NewEncoder(w).Encode(value); er != nil {
+				log.Err(er, "Error writing api response:")
+			}
+		}
+
+		srv.LogAPIAccess(int(time.Since(start).Microseconds()), err, fmt.Sprintf("%s %s %d", r.Method, r.URL.Path, code))
+	})
+}
+
+// authorized checks if r has the bearer token of config.ApiToken,
+// without token no request is authorized
+func authorized(r *http.Request) bool {
+	token := string(config.GetConfig().ApiToken)
+	header := r.Header.Get("Authorization")
+	if token == "" || !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(header, "Bearer ")), []byte(token)) == 1
+}
+
+func getSettings(r *http.Request) (int, any, error) {
+	if r.Method != http.MethodGet {
+		return http.StatusMethodNotAllowed, nil, fmt.Errorf("method %s not allowed", r.Method)
+	}
+	values := map[string]any{}
+	for name, setting := range settings.GetSettings().All() {
+		values[name] = setting.Value()
+	}
+	return http.StatusOK, values, nil
+}
+
+func setting(r *http.Request) (int, any, error) {
+	name := strings.TrimPrefix(r.URL.Path, "/settings/")
+	setting, ok := settings.GetSettings().All()[name]
+	if !ok {
+		return http.StatusNotFound, nil, fmt.Errorf("unknown setting %q, available: %s", name, strings.Join(settingNames(), ", "))
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		return http.StatusOK, setting.Value(), nil
+	case http.MethodPut:
+		data, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+		if err != nil {
+			return http.StatusBadRequest, nil, fmt.Errorf("error reading body: %w", err)
+		}
+		if len(data) > maxBodySize {
+			return http.StatusRequestEntityTooLarge, nil, fmt.Errorf("body exceeds %d bytes", maxBodySize)
+		}
+		if err := setting.Set(data); err != nil {
+			switch {
+			case errors.Is(err, settings.ErrInvalidValue):
+				return http.StatusBadRequest, nil, err
+			case errors.Is(err, settings.ErrReadOnly):
+				return http.StatusMethodNotAllowed, nil, err
+			}
+			return http.StatusInternalServerError, nil, err
+		}
+		return http.StatusOK, setting.Value(), nil
+	default:
+		return http.StatusMethodNotAllowed, nil, fmt.Errorf("method %s not allowed", r.Method)
+	}
+}
+
+func settingNames() []string {
+	var names []string
+	for name := range settings.GetSettings().All() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// userBody is the body of PUT /users/{realm}/{name},
+// the algorithm defaults to bcrypt
+type userBody struct {
+	Password  string `json:"password"`
+	Algorithm string `json:"algorithm"`
+}
+
+func users(r *http.Request) (int, any, error) {
+	realm, name, _ := strings.Cut(strings.Trim(strings.TrimPrefix(r.URL.Path, "/users"), "/"), "/")
+	if strings.Contains(name, "/") {
+		return http.StatusNotFound, nil, fmt.Errorf("unknown path %s", r.URL.Path)
+	}
+
+	if name == "" {
+		if r.Method != http.MethodGet {
+			return http.StatusMethodNotAllowed, nil, fmt.Errorf("method %s not allowed", r.Method)
+		}
+		users, err := auth.GetUsers(realm)
+		if err != nil {
+			return http.StatusInternalServerError, nil, err
+		}
+		return http.StatusOK, users, nil
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		user, found, err := auth.GetUser(realm, name)
+		if err != nil {
+			return http.StatusInternalServerError, nil, err
+		}
+		if !found {
+			return http.StatusNotFound, nil, fmt.Errorf("unknown user %s of realm %s", name, realm)
+		}
+		return http.StatusOK, user, nil
+	case http.MethodPut:
+		var body userBody
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxBodySize)).Decode(&body); err != nil {
+			return http.StatusBadRequest, nil, fmt.Errorf("error reading body: %w", err)
+		}
+		if body.Algorithm == "" {
+			body.Algorithm = auth.Bcrypt
+		}
+		if err := auth.StoreUser(realm, name, body.Password, body.Algorithm); err != nil {
+			if errors.Is(err, auth.ErrInvalidUser) {
+				return http.StatusBadRequest, nil, err
+			}
+			return http.StatusInternalServerError, nil, err
+		}
+		return http.StatusOK, auth.User{Realm: realm, Name: name, Algorithm: body.Algorithm}, nil
+	case http.MethodDelete:
+		if err := auth.DeleteUser(realm, name); err != nil {
+			return http.StatusInternalServerError, nil, err
+		}
+		return http.StatusNoContent, nil, nil
+	default:
+		return http.StatusMethodNotAllowed, nil, fmt.Errorf("method %s not allowed", r.Method)
+	}
+}
@@ -0,0 +1,253 @@
+// Package auth stores the users of HTTP authentication
+// realms, their passwords are only stored as bcrypt or argon2id hashes
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+
+	"server/src"
+	"server/src/log"
+)
+
+// algorithms passwords can be hashed with
+const (
+	Bcrypt   = "bcrypt"
+	Argon2id = "argon2id"
+)
+
+// argon2id parameters of new hashes
+const (
+	argonTime    = 1
+	argonMemory  = 64 * 1024
+	argonThreads = 4
+	argonKeyLen  = 32
+)
+
+// limits of argon2id parameters accepted in stored hashes,
+// so a hash can't make verifying panic or exhaust the memory
+const (
+	maxArgonTime   = 16
+	maxArgonMemory = 1024 * 1024
+	minArgonSalt   = 8
+	minArgonKeyLen = 16
+	maxArgonKeyLen = 1024
+)
+
+// time verified credentials are cached, changes
+// of users on other instances can take this long
+const cacheTime = 30 * time.Second
+
+// ErrInvalidUser is returned for invalid names, passwords or algorithms
+var ErrInvalidUser = errors.New("invalid user")
+
+// User of a realm
+type User struct {
+	Realm string `json:"realm"`
+	Name  string `json:"name"`
+
+	// Algorithm the password is hashed with
+	Algorithm string `json:"algorithm"`
+}
+
+var (
+	// verified maps the sha256 of realm, name and password
+	// of verified credentials to the end of their caching
+	verified = map[[sha256.Size]byte]time.Time{}
+	mutex    sync.Mutex
+)
+
+// hash compared against for unknown users, so they take as long as known ones
+var unknownHash, _ = bcrypt.GenerateFromPassword([]byte("unknown"), bcrypt.DefaultCost)
+
+// Authenticate checks if password is the password of user name in realm
+func Authenticate(realm string, name string, password string) (bool, error) {
+	key := sha256.Sum256([]byte(realm + "\x00" + name + "\x00" + password))
+	mutex.Lock()
+	until, ok := verified[key]
+	mutex.Unlock()
+	if ok && time.Now().Before(until) {
+		return true, nil
+	}
+
+	hash, found, err := getHash(realm, name)
+	if err != nil {
+		return false, err
+	}
+	if !found {
+		_ = bcrypt.CompareHashAndPassword(unknownHash, []byte(password))
+		return false, nil
+	}
+	if ok, err = Verify(hash, password); !ok || err != nil {
+		return false, err
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	now := time.Now()
+	for k, until := range verified {
+		if now.After(until) {
+			delete(verified, k)
+		}
+	}
+	verified[key] = now.Add(cacheTime)
+	return true, nil
+}
+
+// Hash returns the hash of password with algorithm
+func Hash(password string, algorithm string) (string, error) {
+	switch algorithm {
+	case Bcrypt:
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		return string(hash), err
+	case Argon2id:
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, argonTime, argonMemory, argonThreads, argonKeyLen)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argonMemory, argonTime, argonThreads,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	}
+	return "", fmt.Errorf("%w: unknown algorithm %q, available: %s, %s", ErrInvalidUser, algorithm, Bcrypt, Argon2id)
+}
+
+// Verify checks if password matches hash
+func Verify(hash string, password string) (bool, error) {
+	switch algorithm(hash) {
+	case Bcrypt:
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+	case Argon2id:
+		parts := strings.Split(hash, "$")
+		var version, memory, iterations int
+		var threads uint8
+		if len(parts) != 6 {
+			return false, errors.New("invalid argon2id hash")
+		}
+		if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+			return false, errors.New("unsupported argon2id version")
+		}
+		if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil {
+			return false, fmt.Errorf("invalid argon2id parameters: %w", err)
+		}
+		if iterations < 1 || iterations > maxArgonTime || threads < 1 || memory < 8*int(threads) || memory > maxArgonMemory {
+			return false, fmt.Errorf("argon2id parameters out of range (m=%d, t=%d, p=%d)", memory, iterations, threads)
+		}
+		salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+		if err != nil || len(salt) < minArgonSalt {
+			return false, errors.New("invalid argon2id salt")
+		}
+		key, err := base64.RawStdEncoding.DecodeString(parts[5])
+		if err != nil || len(key) < minArgonKeyLen || len(key) > maxArgonKeyLen {
+			return false, errors.New("invalid argon2id key")
+		}
+		other := argon2.IDKey([]byte(password), salt, uint32(iterations), uint32(memory), threads, uint32(len(key)))
+		return subtle.ConstantTimeCompare(key, other) == 1, nil
+	}
+	return false, errors.New("hash of unknown algorithm")
+}
+
+// algorithm returns the algorithm of hash, "" if unknown
+func algorithm(hash string) string {
+	switch {
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		return Bcrypt
+	case strings.HasPrefix(hash, "$argon2id$"):
+		return Argon2id
+	}
+	return ""
+}
+
+func getHash(realm string, name string) (string, bool, error) {
+	var hash string
+	//language=SQL
+	err := src.Session.Query("SELECT hash FROM server.auth_users WHERE realm = ? AND name = ?", realm, name).Scan(&hash)
+	if errors.Is(err, gocql.ErrNotFound) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("error loading user %s of realm %s: %w", name, realm, err)
+	}
+	return hash, true, nil
+}
+
+// GetUsers returns the users of realm, or all users if realm is ""
+func GetUsers(realm string) ([]User, error) {
+	//language=SQL
+	sess := src.Session.Query("SELECT realm, name, hash FROM server.auth_users")
+	if realm != "" {
+		//language=SQL
+		sess = src.Session.Query("SELECT realm, name, hash FROM server.auth_users WHERE realm = ?", realm)
+	}
+	iter := sess.Iter()
+	users := []User{}
+	var user User
+	var hash string
+	for iter.Scan(&user.Realm, &user.Name, &hash) {
+		user.Algorithm = algorithm(hash)
+		users = append(users, user)
+	}
+	if err := iter.Close(); err != nil {
+		log.Debug(fmt.Sprintf("%s, attempts %d, latency: %dns", sess.String(), sess.Attempts(), sess.Latency()))
+		return nil, fmt.Errorf("error loading users: %w", err)
+	}
+	return users, nil
+}
+
+// GetUser returns user name of realm, false if it doesn't exist
+func GetUser(realm string, name string) (User, bool, error) {
+	hash, found, err := getHash(realm, name)
+	return User{Realm: realm, Name: name, Algorithm: algorithm(hash)}, found, err
+}
+
+// StoreUser creates or updates user name of realm with
+// the password hashed with algorithm
+func StoreUser(realm string, name string, password string, algorithm string) error {
+	if realm == "" || name == "" || strings.Contains(name, ":") {
+		return fmt.Errorf("%w: realm and name can't be empty, name can't contain ':'", ErrInvalidUser)
+	}
+	if password == "" {
+		return fmt.Errorf("%w: password can't be empty", ErrInvalidUser)
+	}
+	hash, err := Hash(password, algorithm)
+	if err != nil {
+		return err
+	}
+	//language=SQL
+	if err := src.Session.Query("INSERT INTO server.auth_users (realm, name, hash) VALUES (?,?,?)", realm, name, hash).Exec(); err != nil {
+		return fmt.Errorf("error storing user %s of realm %s: %w", name, realm, err)
+	}
+	clearCache()
+	return nil
+}
+
+// DeleteUser deletes user name of realm
+func DeleteUser(realm string, name string) error {
+	//language=SQL
+	if err := src.Session.Query("DELETE FROM server.auth_users WHERE realm = ? AND name = ?", realm, name).Exec(); err != nil {
+		return fmt.Errorf("error deleting user %s of realm %s: %w", name, realm, err)
+	}
+	clearCache()
+	return nil
+}
+
+func clearCache() {
+	mutex.Lock()
+	verified = map[[sha256.Size]byte]time.Time{}
+	mutex.Unlock()
+}
@@ -0,0 +1,197 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+
+	"server/src"
+	"server/src/config"
+	"server/src/log"
+)
+
+// manager issues and renews the certificates of config.Acme.Hosts,
+// nil if ACME is disabled
+var manager *autocert.Manager
+
+// hosts which get their certificates from manager
+var acmeHosts map[string]bool
+
+// LoadAcme creates the ACME manager if config.Acme is enabled
+func LoadAcme() error {
+	conf := config.GetConfig().Acme
+	if !conf.Enabled {
+		return nil
+	}
+
+	client := &acme.Client{DirectoryURL: conf.DirectoryURL}
+	if conf.DirectoryCA != "" {
+		pem, err := ioutil.ReadFile(conf.DirectoryCA)
+		if err != nil {
+			return fmt.Errorf("error reading ACME directory CA %s: %w", conf.DirectoryCA, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in ACME directory CA %s", conf.DirectoryCA)
+		}
+		client.HTTPClient = &http.Client{
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		}
+	}
+
+	var cache autocert.Cache
+	switch conf.Cache {
+	case "dir":
+		cache = autocert.DirCache(conf.CacheDir)
+	case "db":
+		cache = dbCache{}
+	default:
+		return fmt.Errorf("invalid ACME Cache %q, must be \"dir\" or \"db\"", conf.Cache)
+	}
+
+	acmeHosts = map[string]bool{}
+	for _, host := range conf.Hosts {
+		acmeHosts[strings.ToLower(host)] = true
+	}
+
+	manager = &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      cache,
+		HostPolicy: autocert.HostWhitelist(conf.Hosts...),
+		Client:     client,
+		Email:      conf.Email,
+	}
+	log.Log(fmt.Sprintf("ACME enabled with %s for %v", conf.DirectoryURL, conf.Hosts))
+	return nil
+}
+
+// ObtainAcme gets the certificates of all ACME hosts,
+// so they don't get issued on the first request
+//
+// call after the listeners are started, to answer the challenges
+func ObtainAcme() {
+	if manager == nil {
+		return
+	}
+	for host := range acmeHosts {
+		now := time.Now()
+		cert, err := manager.GetCertificate(&tls.ClientHelloInfo{ServerName: host})
+		if err != nil {
+			log.Err(err, "Error obtaining ACME certificate for", host)
+			continue
+		}
+		log.Log(fmt.Sprintf("Using ACME certificate for %s valid until %s", host, cert.Leaf.NotAfter.Format(time.RFC3339)))
+		log.Debug("Obtained ACME certificate in", time.Since(now))
+	}
+}
+
+// HTTPHandler answers ACME HTTP-01 challenges
+// and redirects everything else to HTTPS
+func HTTPHandler() http.Handler {
+	if manager == nil {
+		return http.HandlerFunc(redirectHTTPS)
+	}
+	return manager.HTTPHandler(http.HandlerFunc(redirectHTTPS))
+}
+
+func redirectHTTPS(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if i := strings.LastIndexByte(host, ':'); i > strings.LastIndexByte(host, ']') {
+		host = host[:i]
+	}
+	if port := httpsPort(); port != "443" {
+		host = net.JoinHostPort(strings.Trim(host, "[]"), port)
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+}
+
+// httpsPort returns the port of the first TCP listener serving
+// the website with TLS, config.Port if there is none
+func httpsPort() string {
+	for _, listener := range config.GetConfig().Listeners {
+		if !listener.TLS.Enabled || listener.RedirectHTTPS || strings.HasPrefix(listener.Address, "unix:") {
+			continue
+		}
+		tcp := false
+		for _, protocol := range listener.Protocols {
+			tcp = tcp || protocol == "http1" || protocol == "http2"
+		}
+		if _, port, err := net.SplitHostPort(listener.Address); tcp && err == nil {
+			return port
+		}
+	}
+	return strconv.Itoa(int(config.GetConfig().Port))
+}
+
+// getAcmeCertificate returns the certificate from manager if
+// the ClientHello is a TLS-ALPN-01 challenge or for an ACME host
+func getAcmeCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, bool, error) {
+	if manager == nil || hello == nil {
+		return nil, false, nil
+	}
+	challenge := len(hello.SupportedProtos) == 1 && hello.SupportedProtos[0] == acme.ALPNProto
+	if !challenge && !acmeHosts[strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))] {
+		return nil, false, nil
+	}
+	cert, err := manager.GetCertificate(hello)
+	return cert, true, err
+}
+
+// dbCache stores the ACME account and certificates inside
+// the DB, so all servers using the DB share them
+type dbCache struct{}
+
+func (dbCache) Get(ctx context.Context, name string) ([]byte, error) {
+	var data []byte
+	//language=SQL
+	sess := src.Session.Query(
+		"SELECT data FROM server.acme WHERE name=?", name,
+	).WithContext(ctx)
+	if err := sess.Scan(&data); err != nil {
+		if errors.Is(err, gocql.ErrNotFound) {
+			return nil, autocert.ErrCacheMiss
+		}
+		log.Err(err, "Error loading ACME data from DB")
+		log.Debug(fmt.Sprintf("%s, attempts %d, latency: %dns", sess.String(), sess.Attempts(), sess.Latency()))
+		return nil, err
+	}
+	return data, nil
+}
+
+func (dbCache) Put(ctx context.Context, name string, data []byte) error {
+	//language=SQL
+	query := src.Session.Query(
+		"INSERT INTO server.acme (name, data) VALUES (?,?)", name, data,
+	).WithContext(ctx)
+	if err := query.Exec(); err != nil {
+		log.Err(err, "Error inserting ACME data into DB")
+		log.Debug(query.Context())
+		return err
+	}
+	return nil
+}
+
+func (dbCache) Delete(ctx context.Context, name string) error {
+	//language=SQL
+	query := src.Session.Query(
+		"DELETE FROM server.acme WHERE name=?", name,
+	).WithContext(ctx)
+	if err := query.Exec(); err != nil {
+		log.Err(err, "Error deleting ACME data from DB")
+		log.Debug(query.Context())
+		return err
+	}
+	return nil
+}
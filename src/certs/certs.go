@@ -0,0 +1,287 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+
+	"server/src/config"
+	"server/src/log"
+)
+
+// pair is a loaded certificate with the files it got loaded from
+type pair struct {
+	config.CertPair
+
+	// certificate used for TLS connections, nil if never loaded
+	cert *tls.Certificate
+
+	// modification times of the files cert was loaded from,
+	// and of the last files that were tried to load
+	loaded, tried modTimes
+
+	// files were missing at the last try
+	missing bool
+}
+
+type modTimes struct {
+	cert time.Time
+	key  time.Time
+}
+
+// all loaded pairs, the first one is config.Certs.Default
+var pairs []*pair
+
+// all configured pairs including failed ones, so
+// they only get tried again after their files changed
+var known map[config.CertPair]*pair
+
+// certificates by the hostnames they are valid for,
+// wildcard certificates are stored as "*.example.com"
+var names map[string]*tls.Certificate
+
+// lock for pairs and names
+var mutex sync.RWMutex
+
+// TLSConfig creates a tls.Config which always uses
+// the currently loaded certificates, every call returns
+// a new config offering protocols with ALPN ("h2", "http/1.1")
+//
+// if ACME is enabled TLS-ALPN-01 challenges get answered too
+func TLSConfig(protocols ...string) *tls.Config {
+	conf := &tls.Config{
+		GetCertificate: GetCertificate,
+		NextProtos:     append([]string{}, protocols...),
+	}
+	if manager != nil {
+		conf.NextProtos = append(conf.NextProtos, acme.ALPNProto)
+	}
+	return conf
+}
+
+// GetCertificate returns the certificate for the server name
+// of the ClientHello, used as tls.Config.GetCertificate
+//
+// ACME hosts get their certificate from the ACME manager,
+// otherwise exact names are preferred over wildcards, if no
+// certificate matches the default certificate is returned
+func GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if cert, ok, err := getAcmeCertificate(hello); ok {
+		return cert, err
+	}
+
+	mutex.RLock()
+	defer mutex.RUnlock()
+	if len(pairs) == 0 {
+		return nil, fmt.Errorf("no certificate loaded")
+	}
+
+	if hello != nil && hello.ServerName != "" {
+		name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+		if cert, ok := names[name]; ok {
+			return cert, nil
+		}
+		if i := strings.IndexByte(name, '.'); i > 0 {
+			if cert, ok := names["*"+name[i:]]; ok {
+				return cert, nil
+			}
+		}
+	}
+	return pairs[0].cert, nil
+}
+
+// Load loads all certificates of config.Certs
+//
+// returns an error if the default certificate could not get loaded,
+// other certificates only get logged and skipped
+func Load() error {
+	return load(true)
+}
+
+// Reload loads all certificates again, certificates
+// which are invalid now keep their old version
+func Reload() {
+	if err := load(true); err != nil {
+		log.Err(err, "Error reloading certificates")
+	}
+}
+
+// Watch checks the certificate and key files every
+// config.CertsWatchInterval seconds and reloads changed ones
+//
+// blocks forever, returns immediately if the interval is 0
+func Watch() {
+	interval := time.Duration(config.GetConfig().CertsWatchInterval) * time.Second
+	if interval == 0 {
+		return
+	}
+	for range time.Tick(interval) {
+		if err := load(false); err != nil {
+			log.Err(err, "Error reloading certificates")
+		}
+	}
+}
+
+// load the pairs of config.Certs, if force is false
+// only pairs with changed files get loaded
+func load(force bool) error {
+	now := time.Now()
+
+	sources, err := listPairs()
+	if err != nil {
+		return err
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	all := map[config.CertPair]*pair{}
+	changed := false
+	newPairs := make([]*pair, 0, len(sources))
+	for i, source := range sources {
+		p, ok := known[source]
+		if !ok {
+			p = &pair{CertPair: source}
+		}
+		all[source] = p
+		reloaded, err := p.load(force)
+		if err != nil {
+			if i == 0 && p.cert == nil {
+				return fmt.Errorf("error loading default certificate: %w", err)
+			}
+			log.Err(err, "Error loading certificate, keeping old one if loaded before")
+		}
+		changed = changed || reloaded
+		if p.cert != nil {
+			newPairs = append(newPairs, p)
+		}
+	}
+	known = all
+	if !changed && samePairs(newPairs, pairs) {
+		return nil
+	}
+
+	pairs = newPairs
+	names = map[string]*tls.Certificate{}
+	// iterate backwards, so earlier pairs win on duplicate names
+	for i := len(pairs) - 1; i >= 0; i-- {
+		for _, name := range pairs[i].cert.Leaf.DNSNames {
+			names[strings.ToLower(name)] = pairs[i].cert
+		}
+		if len(pairs[i].cert.Leaf.DNSNames) == 0 && pairs[i].cert.Leaf.Subject.CommonName != "" {
+			names[strings.ToLower(pairs[i].cert.Leaf.Subject.CommonName)] = pairs[i].cert
+		}
+	}
+
+	for _, p := range pairs {
+		log.Log(fmt.Sprintf("Using certificate %s for %v valid until %s", p.Cert, p.hostnames(), p.cert.Leaf.NotAfter.Format(time.RFC3339)))
+		if time.Now().After(p.cert.Leaf.NotAfter) {
+			log.Err(nil, fmt.Sprintf("Certificate %s expired at %s", p.Cert, p.cert.Leaf.NotAfter.Format(time.RFC3339)))
+		}
+	}
+	log.Debug("Loaded certificates in", time.Since(now))
+	return nil
+}
+
+// listPairs returns all configured pairs, the default pair first
+func listPairs() ([]config.CertPair, error) {
+	certs := config.GetConfig().Certs
+	sources := append([]config.CertPair{certs.Default}, certs.Pairs...)
+	if certs.Dir == "" {
+		return sources, nil
+	}
+
+	files, err := ioutil.ReadDir(certs.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading certificate directory %s: %w", certs.Dir, err)
+	}
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".crt" {
+			continue
+		}
+		name := strings.TrimSuffix(file.Name(), ".crt")
+		sources = append(sources, config.CertPair{
+			Cert: filepath.Join(certs.Dir, name+".crt"),
+			Key:  filepath.Join(certs.Dir, name+".key"),
+		})
+	}
+	return sources, nil
+}
+
+// load the certificate, if force is false only if the files changed
+// since the last try
+//
+// on error the old certificate stays
+func (p *pair) load(force bool) (bool, error) {
+	times, err := p.getModTimes()
+	if err != nil {
+		// missing files only get reported once
+		if !force && p.missing {
+			return false, nil
+		}
+		p.missing = true
+		return false, err
+	}
+	p.missing = false
+	if !force && (times.equal(p.loaded) || times.equal(p.tried)) {
+		return false, nil
+	}
+	p.tried = times
+
+	cert, err := tls.LoadX509KeyPair(p.Cert, p.Key)
+	if err != nil {
+		return false, fmt.Errorf("error loading certificate %s with key %s: %w", p.Cert, p.Key, err)
+	}
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return false, fmt.Errorf("error parsing certificate %s: %w", p.Cert, err)
+	}
+
+	p.cert = &cert
+	p.loaded = times
+	return true, nil
+}
+
+// samePairs returns if a and b contain the same pairs in the same order
+func samePairs(a []*pair, b []*pair) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *pair) hostnames() []string {
+	if len(p.cert.Leaf.DNSNames) == 0 {
+		return []string{p.cert.Leaf.Subject.CommonName}
+	}
+	return p.cert.Leaf.DNSNames
+}
+
+func (p *pair) getModTimes() (modTimes, error) {
+	certInfo, err := os.Stat(p.Cert)
+	if err != nil {
+		return modTimes{}, fmt.Errorf("error reading certificate %s: %w", p.Cert, err)
+	}
+	keyInfo, err := os.Stat(p.Key)
+	if err != nil {
+		return modTimes{}, fmt.Errorf("error reading key %s: %w", p.Key, err)
+	}
+	return modTimes{cert: certInfo.ModTime(), key: keyInfo.ModTime()}, nil
+}
+
+func (m modTimes) equal(other modTimes) bool {
+	return m.cert.Equal(other.cert) && m.key.Equal(other.key)
+}
@@ -44,8 +44,282 @@ type DB struct {
 	Keyspace string `yaml:"Keyspace"`
 }
 
+// Certs struct containing information about the TLS certificates
+//
+// the certificate gets chosen by the server name the client
+// requests (SNI), if no certificate matches, Default is used
+type Certs struct {
+
+	// Default certificate, used if no other certificate
+	// matches the requested server name
+	//
+	// default: {Cert: "certs/cert.crt", Key: "certs/key.key"}
+	Default CertPair `yaml:"Default"`
+
+	// Pairs of additional certificates, which get used
+	// for the hostnames they contain
+	//
+	// default: []
+	Pairs []CertPair `yaml:"Pairs"`
+
+	// Dir with additional certificates, every <name>.crt
+	// needs a matching <name>.key in the same directory
+	// leave empty to not load a directory
+	//
+	// default: ""
+	Dir string `yaml:"Dir"`
+}
+
+// CertPair of certificate and its private key, both PEM encoded
+type CertPair struct {
+
+	// Cert file path of the certificate (chain)
+	Cert string `yaml:"Cert"`
+
+	// Key file path of the private key
+	Key string `yaml:"Key"`
+}
+
+// Acme struct containing information about automatic
+// certificate issuance and renewal with an ACME directory
+// (e.g. Let's Encrypt)
+//
+// challenges get answered with TLS-ALPN-01 on PortHTTPS
+// and HTTP-01 on PortHTTP, if PortHTTP is set
+type Acme struct {
+
+	// Enabled turns on issuing certificates for Hosts
+	//
+	// default: false
+	Enabled bool `yaml:"Enabled"`
+
+	// DirectoryURL of the ACME directory to use
+	// can be changed to a staging or a local test server (e.g. Pebble)
+	//
+	// default: "https://acme-v02.api.letsencrypt.org/directory"
+	DirectoryURL string `yaml:"DirectoryURL"`
+
+	// DirectoryCA file with PEM encoded CA certificates to trust
+	// for the connection to the ACME directory, for test servers
+	// with their own CA, leave empty to use the system CAs
+	//
+	// default: ""
+	DirectoryCA string `yaml:"DirectoryCA"`
+
+	// Email used as contact for the ACME account
+	//
+	// default: ""
+	Email string `yaml:"Email"`
+
+	// Hosts to get certificates for, requests for
+	// other hosts use the certificates from Certs
+	//
+	// default: []
+	Hosts []string `yaml:"Hosts"`
+
+	// Cache where account and issued certificates get stored
+	// "dir" stores inside CacheDir, "db" stores in the
+	// Database, so multiple servers can share them
+	//
+	// default: "dir"
+	Cache string `yaml:"Cache"`
+
+	// CacheDir directory for the "dir" Cache
+	//
+	// default: "certs/acme"
+	CacheDir string `yaml:"CacheDir"`
+}
+
+// Listener struct containing information about an address
+// to serve the website on
+type Listener struct {
+
+	// Address to listen on as host:port, IPv6 hosts are written
+	// in brackets ("[::1]:8443"), an empty host listens on all
+	// interfaces (":8443")
+	// "unix:/path/to/server.sock" listens on a Unix socket,
+	// which only supports http1 and http2
+	Address string `yaml:"Address"`
+
+	// Protocols to serve on Address, "http1" and "http2" over TCP,
+	// "http3" over UDP, http2 and http3 need TLS
+	Protocols []string `yaml:"Protocols"`
+
+	// RedirectHTTPS only answers ACME HTTP-01 challenges and
+	// redirects everything else to HTTPS, instead of serving the website
+	//
+	// default: false
+	RedirectHTTPS bool `yaml:"RedirectHTTPS"`
+
+	// TLS settings of this listener
+	//
+	// see ListenerTLS
+	TLS ListenerTLS `yaml:"TLS"`
+}
+
+// ListenerTLS struct containing the TLS settings of a Listener
+type ListenerTLS struct {
+
+	// Enabled serves with TLS using the certificates from Certs and Acme
+	//
+	// default: false
+	Enabled bool `yaml:"Enabled"`
+
+	// MinVersion of TLS to accept, "1.0", "1.1", "1.2" or "1.3"
+	// http3 always uses "1.3"
+	//
+	// default: "1.2"
+	MinVersion string `yaml:"MinVersion"`
+}
+
+// Host struct containing information about a virtual host,
+// which serves its own directory for the hostnames it contains
+type Host struct {
+
+	// Names of the host as sent in the Host header,
+	// "*.example.com" matches all subdomains of example.com
+	Names []string `yaml:"Names"`
+
+	// SitesDir to serve for this host, every host
+	// gets its own cache of the directory
+	SitesDir string `yaml:"SitesDir"`
+
+	// DefaultSite to serve if no path was specified
+	// leave empty to use the DefaultSite setting
+	//
+	// default: ""
+	DefaultSite string `yaml:"DefaultSite"`
+
+	// NotFound file inside SitesDir served with 404 for
+	// paths that don't exist, leave empty for the default error page
+	//
+	// default: ""
+	NotFound string `yaml:"NotFound"`
+}
+
+// Proxy struct containing information about a route,
+// which forwards requests to upstream HTTP servers
+type Proxy struct {
+
+	// Path prefix of the requests to forward, matching
+	// whole segments ("/api" forwards "/api/x", not "/apix")
+	Path string `yaml:"Path"`
+
+	// Upstreams to forward to as base URLs ("http://10.0.0.2:8080")
+	Upstreams []string `yaml:"Upstreams"`
+
+	// Balancing between the Upstreams, "round-robin"
+	// or "least-connections"
+	//
+	// default: "round-robin"
+	Balancing string `yaml:"Balancing"`
+
+	// StripPath removes Path from the forwarded path
+	//
+	// default: false
+	StripPath bool `yaml:"StripPath"`
+
+	// Timeout in seconds to wait for the response
+	// headers of an upstream, answers 504 afterwards
+	//
+	// default: 30
+	Timeout uint16 `yaml:"Timeout"`
+
+	// HealthCheck of the Upstreams
+	//
+	// see HealthCheck
+	HealthCheck HealthCheck `yaml:"HealthCheck"`
+
+	// RequestHeaders set on forwarded requests,
+	// an empty value removes the header
+	//
+	// default: {}
+	RequestHeaders map[string]string `yaml:"RequestHeaders"`
+
+	// ResponseHeaders set on responses of the upstreams,
+	// an empty value removes the header
+	//
+	// default: {}
+	ResponseHeaders map[string]string `yaml:"ResponseHeaders"`
+}
+
+// FastCGI struct containing information about a gateway,
+// which runs scripts of requests on a FastCGI responder (e.g. php-fpm)
+type FastCGI struct {
+
+	// Path prefix of the requests to run, either Path or Extension
+	// has to be set, with Path and Script every request runs Script
+	//
+	// default: ""
+	Path string `yaml:"Path"`
+
+	// Extension of the scripts to run ("php"), "/a.php/b"
+	// runs the script /a.php with PATH_INFO /b
+	//
+	// default: ""
+	Extension string `yaml:"Extension"`
+
+	// Address of the responder, "host:port" for TCP
+	// or "unix:/path/to/socket" for unix sockets
+	Address string `yaml:"Address"`
+
+	// Root directory of the scripts on the responder,
+	// SCRIPT_FILENAME is Root followed by SCRIPT_NAME
+	//
+	// default: ""
+	Root string `yaml:"Root"`
+
+	// Script file on the responder run for all requests of Path,
+	// the path after Path is passed as PATH_INFO
+	// leave empty to run the requested script
+	//
+	// default: ""
+	Script string `yaml:"Script"`
+
+	// Timeout in seconds to wait for the response
+	// headers of the responder, answers 504 afterwards
+	//
+	// default: 30
+	Timeout uint16 `yaml:"Timeout"`
+
+	// Params passed additionally to the CGI environment
+	//
+	// default: {}
+	Params map[string]string `yaml:"Params"`
+}
+
+// HealthCheck struct containing information about
+// the active health checks of Proxy upstreams
+type HealthCheck struct {
+
+	// Path requested with GET from every upstream,
+	// a 2xx or 3xx status marks the upstream healthy
+	//
+	// default: "/"
+	Path string `yaml:"Path"`
+
+	// Interval in seconds between checks, 0 disables
+	// the checks and all upstreams stay healthy
+	//
+	// default: 0
+	Interval uint16 `yaml:"Interval"`
+
+	// Timeout in seconds of a check
+	//
+	// default: 5
+	Timeout uint16 `yaml:"Timeout"`
+}
+
+// Secret is a string which is hidden when the config gets logged
+type Secret string
+
+func (Secret) String() string {
+	return "<hidden>"
+}
+
 type config struct {
 	// PortHTTPS for the website must be between 0 and 65536
+	// used for HTTP/1.1 and HTTP/2 over TCP
 	// this comes from the Dockerfile and should
 	// not get changed via the config file if used with Docker,
 	// as it gets overridden with the environment variables
@@ -53,6 +327,23 @@ type config struct {
 	// default: 8443
 	Port uint16 `yaml:"PortHTTPS" env:"Port"`
 
+	// PortHTTP3 for the website over QUIC must be between 0 and 65536
+	// HTTP/3 listens on UDP, so this can be the same number as Port,
+	// but can also be set separately, if the UDP port gets mapped
+	// differently (e.g. Docker)
+	//
+	// TCP clients get this port advertised via the Alt-Svc header
+	//
+	// default: 8443
+	PortHTTP3 uint16 `yaml:"PortHTTP3" env:"PortHTTP3"`
+
+	// PortHTTP for unencrypted HTTP must be between 0 and 65536
+	// only answers ACME HTTP-01 challenges and redirects
+	// everything else to HTTPS, set to 0 to disable
+	//
+	// default: 0
+	PortHTTP uint16 `yaml:"PortHTTP" env:"PortHTTP"`
+
 	// ApiPort used for the api must be between 0 and 65536
 	// should be different from Port to avoid trying to serve
 	// api by server
@@ -60,6 +351,20 @@ type config struct {
 	// default: 18266
 	ApiPort uint16 `yaml:"ApiPort" env:"ApiPort"`
 
+	// ApiHost the api listens on, the api can change settings and
+	// users, so it should only be reachable from trusted hosts
+	// use "::" to listen on all interfaces, "" disables the api
+	//
+	// default: localhost
+	ApiHost string `yaml:"ApiHost" env:"ApiHost"`
+
+	// ApiToken every api request has to send as
+	// "Authorization: Bearer <token>", the api
+	// is disabled as long as no token is set
+	//
+	// default: ""
+	ApiToken Secret `yaml:"ApiToken" env:"ApiToken"`
+
 	// change to serve root for serving files
 	// can be relative to the server main.go
 	// or absolute
@@ -70,22 +375,80 @@ type config struct {
 	// default: ./site
 	SitesDir string `yaml:"SitesDir"`
 
+	// virtual hosts serving their own directory,
+	// requests for other hosts are served from SitesDir
+	//
+	// see Host
+	//
+	// default: []
+	Hosts []Host `yaml:"Hosts"`
+
+	// routes forwarding requests to upstream HTTP servers,
+	// the first route with a matching path prefix is used
+	// proxied requests are not served from the SitesDir
+	//
+	// see Proxy
+	//
+	// default: []
+	Proxies []Proxy `yaml:"Proxies"`
+
+	// gateways running scripts on FastCGI responders, the first
+	// gateway with a matching path prefix or extension is used
+	// proxies are matched before gateways
+	//
+	// see FastCGI
+	//
+	// default: []
+	FastCGI []FastCGI `yaml:"FastCGI"`
+
 	// removes Debug logs from console if set to true
 	// disabling improves cache loading and serving speed
 	//
 	// default: false
 	Debug bool `yaml:"Debug"`
 
+	// interval in seconds to check the certificate and key
+	// files of Certs for changes, changed files get reloaded without restart
+	// set to 0 to disable, reloading is also possible with SIGHUP
+	//
+	// default: 30
+	CertsWatchInterval uint16 `yaml:"CertsWatchInterval"`
+
+	// time in seconds to wait for running requests and
+	// pending access logs on shutdown (SIGTERM/SIGINT),
+	// before the server gets closed anyway
+	// should be lower than the stop timeout of Docker (10s)
+	//
+	// default: 8
+	ShutdownTimeout uint16 `yaml:"ShutdownTimeout"`
+
 	// Configuration for Database connection
 	//
 	// see DB
 	Database DB `yaml:"Database"`
+
+	// Configuration for TLS certificates
+	//
+	// see Certs
+	Certs Certs `yaml:"Certs"`
+
+	// Configuration for automatic certificates with ACME
+	//
+	// see Acme
+	Acme Acme `yaml:"Acme"`
+
+	// Listeners to serve the website on
+	// if empty, listens on all interfaces on PortHTTPS
+	// (http1, http2), PortHTTP3 (http3) and PortHTTP (RedirectHTTPS)
+	//
+	// see Listener
+	//
+	// default: []
+	Listeners []Listener `yaml:"Listeners"`
 }
 
 const (
 	ConfigFile = "server.yml"
-	CertsFile  = "certs/cert.crt"
-	KeyFile    = "certs/key.key"
 )
 
 var conf config
@@ -111,16 +474,83 @@ func LoadConfig() {
 
 	// load some values from env (for docker)
 	loadEnv(&conf)
+
+	if len(conf.Listeners) == 0 {
+		conf.Listeners = portListeners()
+	}
+	for i := range conf.Listeners {
+		if conf.Listeners[i].TLS.MinVersion == "" {
+			conf.Listeners[i].TLS.MinVersion = "1.2"
+		}
+	}
+	for i := range conf.Proxies {
+		proxyDefaults(&conf.Proxies[i])
+	}
+	for i := range conf.FastCGI {
+		if conf.FastCGI[i].Timeout == 0 {
+			conf.FastCGI[i].Timeout = 30
+		}
+	}
+}
+
+// proxyDefaults sets the default values of unset Proxy fields
+func proxyDefaults(proxy *Proxy) {
+	if proxy.Balancing == "" {
+		proxy.Balancing = "round-robin"
+	}
+	if proxy.Timeout == 0 {
+		proxy.Timeout = 30
+	}
+	if proxy.HealthCheck.Path == "" {
+		proxy.HealthCheck.Path = "/"
+	}
+	if proxy.HealthCheck.Timeout == 0 {
+		proxy.HealthCheck.Timeout = 5
+	}
+}
+
+// portListeners creates the default listeners from the ports
+func portListeners() []Listener {
+	listeners := []Listener{
+		{
+			Address:   fmt.Sprintf(":%d", conf.Port),
+			Protocols: []string{"http1", "http2"},
+			TLS:       ListenerTLS{Enabled: true},
+		},
+		{
+			Address:   fmt.Sprintf(":%d", conf.PortHTTP3),
+			Protocols: []string{"http3"},
+			TLS:       ListenerTLS{Enabled: true},
+		},
+	}
+	if conf.PortHTTP != 0 {
+		listeners = append(listeners, Listener{
+			Address:       fmt.Sprintf(":%d", conf.PortHTTP),
+			Protocols:     []string{"http1"},
+			RedirectHTTPS: true,
+		})
+	}
+	return listeners
 }
 
 func defaultConfig() {
 	conf.Port = 8443
+	conf.PortHTTP3 = 8443
+	conf.PortHTTP = 0
 	conf.ApiPort = 18266
+	conf.ApiHost = "localhost"
 
 	conf.SitesDir = "./site"
+	conf.Hosts = []Host{}
+	conf.Proxies = []Proxy{}
+	conf.FastCGI = []FastCGI{}
 
 	conf.Debug = false
 
+	conf.CertsWatchInterval = 30
+
+	conf.ShutdownTimeout = 8
+
 	conf.Database = DB{
 		Hosts:    []string{"no host provided"},
 		Port:     0,
@@ -128,6 +558,27 @@ func defaultConfig() {
 		Keyspace: "no keyspace provided",
 		Password: "no database provided",
 	}
+
+	conf.Certs = Certs{
+		Default: CertPair{
+			Cert: "certs/cert.crt",
+			Key:  "certs/key.key",
+		},
+		Pairs: []CertPair{},
+		Dir:   "",
+	}
+
+	conf.Acme = Acme{
+		Enabled:      false,
+		DirectoryURL: "https://acme-v02.api.letsencrypt.org/directory",
+		DirectoryCA:  "",
+		Email:        "",
+		Hosts:        []string{},
+		Cache:        "dir",
+		CacheDir:     "certs/acme",
+	}
+
+	conf.Listeners = []Listener{}
 }
 
 func loadEnv(cfg *config) {
@@ -148,8 +599,8 @@ func loadEnv(cfg *config) {
 			continue
 		}
 
-		// fing env
-		if env := os.Getenv(tag); env != "" {
+		// fing env, set but empty strings apply too (e.g. ApiHost="" disables the api)
+		if env, ok := os.LookupEnv(tag); ok {
 			switch confFieldType.Type.Kind() {
 			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
 				data, err := strconv.Atoi(env)
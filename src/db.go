@@ -35,3 +35,9 @@ func DBInit() {
 	}
 	log.Log("Session Connection established")
 }
+
+// DBClose Close DB Connection
+func DBClose() {
+	Session.Close()
+	log.Log("Session Connection closed")
+}
@@ -0,0 +1,311 @@
+// Package fcgi is a client for FastCGI responders (e.g. php-fpm)
+package fcgi
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"server/src/log"
+)
+
+// record types
+const (
+	typeBeginRequest = 1
+	typeEndRequest   = 3
+	typeParams       = 4
+	typeStdin        = 5
+	typeStdout       = 6
+	typeStderr       = 7
+)
+
+const (
+	version       = 1
+	roleResponder = 1
+
+	// every connection only carries one request
+	requestID = 1
+
+	maxContent = 65535
+)
+
+// Client sends requests to the FastCGI responder at Address
+type Client struct {
+	// Network of the responder, "tcp" or "unix"
+	Network string
+
+	// Address of the responder
+	Address string
+
+	// Timeout for connecting and receiving the response headers,
+	// counted from the end of the request body, so uploads can take longer
+	Timeout time.Duration
+}
+
+// Do runs a request with the CGI params and body, the response
+// headers are parsed from the CGI response, the Body of the response
+// streams the rest of stdout and has to be closed
+//
+// body is streamed while the response is read, it is not read
+// anymore once Do returned an error or the Body got closed
+//
+// timeouts return errors with Timeout() true
+func (client *Client) Do(ctx context.Context, params map[string]string, body io.Reader) (*http.Response, error) {
+	dialer := net.Dialer{Timeout: client.Timeout}
+	conn, err := dialer.DialContext(ctx, client.Network, client.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &writer{conn: conn}
+	if client.Timeout > 0 {
+		_ = conn.SetWriteDeadline(time.Now().Add(client.Timeout))
+	}
+	if err := w.beginRequest(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := w.params(params); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// the body has no timeout, it is limited by reading it
+	_ = conn.SetWriteDeadline(time.Time{})
+
+	// stdin gets written while the response is read, so responders
+	// can answer before reading the whole body, the timeout for the
+	// response headers starts once the body is written
+	deadline := &readDeadline{conn: conn, timeout: client.Timeout}
+	stdinErr := make(chan error, 1)
+	stdinDone := make(chan struct{})
+	go func() {
+		defer close(stdinDone)
+		err := w.stdin(body)
+		deadline.start()
+		stdinErr <- err
+	}()
+	// closes the connection, so stdin stops writing, and waits until it stopped
+	abort := func() {
+		conn.Close()
+		<-stdinDone
+	}
+
+	r := &reader{conn: conn, stdinErr: stdinErr}
+	buffered := bufio.NewReader(r)
+	header, err := textproto.NewReader(buffered).ReadMIMEHeader()
+	if err != nil {
+		abort()
+		if err == io.EOF {
+			err = errors.New("responder closed the connection without response")
+		}
+		return nil, fmt.Errorf("error reading response headers: %w", err)
+	}
+	// the body has no timeout, so it can be streamed
+	deadline.stop()
+
+	response := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header(header),
+		Body:       &responseBody{Reader: buffered, abort: abort},
+	}
+	if status := header.Get("Status"); status != "" {
+		code, _, _ := strings.Cut(status, " ")
+		response.StatusCode, err = strconv.Atoi(code)
+		if err != nil || response.StatusCode < 100 || response.StatusCode > 999 {
+			abort()
+			return nil, fmt.Errorf("invalid status %q", status)
+		}
+		response.Header.Del("Status")
+	} else if header.Get("Location") != "" {
+		response.StatusCode = http.StatusFound
+	}
+	return response, nil
+}
+
+// readDeadline sets the deadline for reading the response headers
+// once the request is written, unless they were read already
+type readDeadline struct {
+	conn    net.Conn
+	timeout time.Duration
+	mutex   sync.Mutex
+	stopped bool
+}
+
+func (d *readDeadline) start() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.timeout > 0 && !d.stopped {
+		_ = d.conn.SetReadDeadline(time.Now().Add(d.timeout))
+	}
+}
+
+func (d *readDeadline) stop() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.stopped = true
+	_ = d.conn.SetReadDeadline(time.Time{})
+}
+
+// writer writes records of the request
+type writer struct {
+	conn  net.Conn
+	mutex sync.Mutex
+}
+
+func (w *writer) record(recordType uint8, content []byte) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	padding := -len(content) & 7
+	header := [8]byte{version, recordType, 0, requestID}
+	binary.BigEndian.PutUint16(header[4:6], uint16(len(content)))
+	header[6] = uint8(padding)
+	record := append(append(header[:], content...), make([]byte, padding)...)
+	_, err := w.conn.Write(record)
+	return err
+}
+
+func (w *writer) beginRequest() error {
+	// flags 0, the responder closes the connection after the request
+	return w.record(typeBeginRequest, []byte{0, roleResponder, 0, 0, 0, 0, 0, 0})
+}
+
+// params writes the name-value pairs of params, followed by an empty record
+func (w *writer) params(params map[string]string) error {
+	var buf []byte
+	for name, value := range params {
+		pair := appendLength(appendLength(nil, len(name)), len(value))
+		pair = append(append(pair, name...), value...)
+		if len(buf)+len(pair) > maxContent && len(buf) > 0 {
+			if err := w.record(typeParams, buf); err != nil {
+				return err
+			}
+			buf = nil
+		}
+		if len(pair) > maxContent {
+			return fmt.Errorf("param %s is too long", name)
+		}
+		buf = append(buf, pair...)
+	}
+	if len(buf) > 0 {
+		if err := w.record(typeParams, buf); err != nil {
+			return err
+		}
+	}
+	return w.record(typeParams, nil)
+}
+
+// stdin streams body, followed by an empty record
+func (w *writer) stdin(body io.Reader) error {
+	if body != nil {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := body.Read(buf)
+			if n > 0 {
+				if err := w.record(typeStdin, buf[:n]); err != nil {
+					return err
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("error reading request body: %w", err)
+			}
+		}
+	}
+	return w.record(typeStdin, nil)
+}
+
+func appendLength(buf []byte, length int) []byte {
+	if length < 128 {
+		return append(buf, uint8(length))
+	}
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(length)|1<<31)
+	return append(buf, b[:]...)
+}
+
+// reader reads the stdout stream of the response,
+// stderr gets logged, returns io.EOF after the end of the request
+type reader struct {
+	conn     net.Conn
+	stdinErr <-chan error
+	content  []byte
+	ended    bool
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.content) == 0 {
+		if r.ended {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.content)
+	r.content = r.content[n:]
+	return n, nil
+}
+
+// next reads the next record
+func (r *reader) next() error {
+	var header [8]byte
+	if _, err := io.ReadFull(r.conn, header[:]); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			select {
+			case err := <-r.stdinErr:
+				if err != nil {
+					return fmt.Errorf("error writing request: %w", err)
+				}
+			default:
+			}
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if header[0] != version {
+		return fmt.Errorf("invalid FastCGI version %d", header[0])
+	}
+	length := int(binary.BigEndian.Uint16(header[4:6])) + int(header[6])
+	content := make([]byte, length)
+	if _, err := io.ReadFull(r.conn, content); err != nil {
+		return err
+	}
+	content = content[:binary.BigEndian.Uint16(header[4:6])]
+
+	switch header[1] {
+	case typeStdout:
+		r.content = content
+	case typeStderr:
+		if len(content) > 0 {
+			log.Err(nil, "FastCGI stderr:", strings.TrimSpace(string(content)))
+		}
+	case typeEndRequest:
+		r.ended = true
+	}
+	return nil
+}
+
+// responseBody streams stdout, closes the connection
+// and waits until stdin stopped on Close
+type responseBody struct {
+	*bufio.Reader
+	abort func()
+}
+
+func (b *responseBody) Close() error {
+	b.abort()
+	return nil
+}
@@ -0,0 +1,279 @@
+package listen
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	lg "log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/http3"
+
+	"server/src/certs"
+	"server/src/config"
+	"server/src/log"
+)
+
+const (
+	HTTP1 = "http1"
+	HTTP2 = "http2"
+	HTTP3 = "http3"
+
+	unixPrefix = "unix:"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// servers over TCP and Unix sockets
+var servers []*http.Server
+
+// servers over UDP
+var servers3 []*http3.Server
+
+// running servers, done after the servers stopped
+var running sync.WaitGroup
+
+// Start starts all config.Listeners serving handler
+//
+// returns an error if a listener is invalid or can't be opened,
+// errors while serving panic like before
+func Start(handler http.Handler) error {
+	listeners := config.GetConfig().Listeners
+
+	// create all http3 servers first, so the TCP servers can advertise them
+	for _, listener := range listeners {
+		protocols, err := getProtocols(listener)
+		if err != nil {
+			return err
+		}
+		if protocols[HTTP3] {
+			if !listener.TLS.Enabled {
+				return fmt.Errorf("listener %s: http3 needs TLS", listener.Address)
+			}
+			if strings.HasPrefix(listener.Address, unixPrefix) {
+				return fmt.Errorf("listener %s: http3 is not possible on Unix sockets", listener.Address)
+			}
+			servers3 = append(servers3, &http3.Server{
+				Addr:      listener.Address,
+				Handler:   handler,
+				TLSConfig: certs.TLSConfig(),
+			})
+		}
+	}
+
+	for _, listener := range listeners {
+		protocols, _ := getProtocols(listener)
+		if !protocols[HTTP1] && !protocols[HTTP2] {
+			continue
+		}
+		server, err := createServer(listener, protocols, handler)
+		if err != nil {
+			return err
+		}
+		ln, err := listen(listener.Address)
+		if err != nil {
+			return err
+		}
+		servers = append(servers, server)
+
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			startServer(server, ln)
+		}()
+	}
+
+	for _, server := range servers3 {
+		server := server
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			startServer3(server)
+		}()
+	}
+	return nil
+}
+
+// Shutdown stops accepting new connections over TCP and waits
+// for running connections, the returned chan gets closed once done
+//
+// http3 servers keep running until Close
+func Shutdown(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for _, server := range servers {
+		server := server
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := server.Shutdown(ctx); err != nil {
+				log.Err(err, "Error shutting down webServer", server.Addr)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	return done
+}
+
+// Close closes the http3 servers immediately, http3 has
+// no graceful shutdown, so call after all requests are done
+func Close() {
+	for _, server := range servers3 {
+		if err := server.Close(); err != nil {
+			log.Err(err, "Error closing webServer HTTP/3", server.Addr)
+		}
+	}
+}
+
+// Wait blocks until all servers stopped
+func Wait() {
+	running.Wait()
+}
+
+func getProtocols(listener config.Listener) (map[string]bool, error) {
+	protocols := map[string]bool{}
+	for _, protocol := range listener.Protocols {
+		switch protocol {
+		case HTTP1, HTTP3:
+		case HTTP2:
+			if !listener.TLS.Enabled {
+				return nil, fmt.Errorf("listener %s: http2 needs TLS", listener.Address)
+			}
+		default:
+			return nil, fmt.Errorf("listener %s: unknown protocol %q", listener.Address, protocol)
+		}
+		protocols[protocol] = true
+	}
+	if len(protocols) == 0 {
+		return nil, fmt.Errorf("listener %s: no protocols", listener.Address)
+	}
+	return protocols, nil
+}
+
+func createServer(listener config.Listener, protocols map[string]bool, handler http.Handler) (*http.Server, error) {
+	if listener.RedirectHTTPS {
+		handler = certs.HTTPHandler()
+	} else if len(servers3) > 0 {
+		handler = altSvc(servers3[0], handler)
+	}
+	if !protocols[HTTP1] {
+		handler = onlyHTTP2(handler)
+	}
+
+	server := &http.Server{
+		Addr:     listener.Address,
+		Handler:  handler,
+		ErrorLog: lg.New(&log.LogWriter{}, "", 0),
+	}
+	if listener.TLS.Enabled {
+		version, ok := tlsVersions[listener.TLS.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("listener %s: unknown TLS MinVersion %q", listener.Address, listener.TLS.MinVersion)
+		}
+		// only the protocols of the listener get offered,
+		// so clients don't negotiate h2 on HTTP/1 listeners
+		var alpn []string
+		if protocols[HTTP2] {
+			alpn = append(alpn, "h2")
+		}
+		if protocols[HTTP1] {
+			alpn = append(alpn, "http/1.1")
+		}
+		server.TLSConfig = certs.TLSConfig(alpn...)
+		server.TLSConfig.MinVersion = version
+	}
+	if !protocols[HTTP2] {
+		// non nil empty map disables http2
+		server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	}
+	return server, nil
+}
+
+// listen opens a TCP or Unix socket listener for address
+func listen(address string) (net.Listener, error) {
+	if path := strings.TrimPrefix(address, unixPrefix); path != address {
+		// remove socket left over from previous run
+		if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			if err := os.Remove(path); err != nil {
+				return nil, fmt.Errorf("listener %s: error removing old socket: %w", address, err)
+			}
+		}
+		ln, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, fmt.Errorf("listener %s: %w", address, err)
+		}
+		return ln, nil
+	}
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("listener %s: %w", address, err)
+	}
+	return ln, nil
+}
+
+func startServer(server *http.Server, ln net.Listener) {
+	var err error
+	// blocks if success
+	if server.TLSConfig != nil {
+		log.Log(fmt.Sprintf("Serve Webserver with TLS started on https://%s", server.Addr))
+		// certificate is provided by TLSConfig
+		err = server.ServeTLS(ln, "", "")
+	} else {
+		log.Log(fmt.Sprintf("Serve Webserver without TLS started on http://%s", server.Addr))
+		err = server.Serve(ln)
+	}
+
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Err(err, "Error starting webServer", server.Addr)
+		panic(err)
+	}
+}
+
+func startServer3(server *http3.Server) {
+	// blocks if success
+	log.Log(fmt.Sprintf("ListenAndServe Webserver HTTP/3 with TLS started on https://%s", server.Addr))
+	err := server.ListenAndServe()
+
+	if err != nil && !errors.Is(err, quic.ErrServerClosed) && !errors.Is(err, http.ErrServerClosed) {
+		log.Err(err, "Error starting webServer HTTP/3", server.Addr)
+		panic(err)
+	}
+}
+
+// altSvc wraps the handler of the TCP server to advertise
+// the HTTP/3 server with an Alt-Svc header, so clients
+// supporting QUIC can upgrade
+func altSvc(server3 *http3.Server, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := server3.SetQuicHeaders(w.Header()); err != nil {
+			log.Debug("Could not set Alt-Svc header:", err)
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// onlyHTTP2 rejects HTTP/1 requests, net/http always accepts them
+func onlyHTTP2(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor < 2 {
+			w.Header().Set("Connection", "close")
+			http.Error(w, "HTTP/2 required", http.StatusHTTPVersionNotSupported)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,230 @@
+package settings
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// JSON representations of the settings used by the api,
+// regexes are written as their source
+
+type mimeJSON struct {
+	Extension string `json:"extension"`
+	Type      string `json:"type"`
+}
+
+func (mime Mime) MarshalJSON() ([]byte, error) {
+	return json.Marshal(mimeJSON{Extension: mime.Regex.String(), Type: mime.Type})
+}
+
+func (mime *Mime) UnmarshalJSON(data []byte) error {
+	var m mimeJSON
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	regex, err := regexp.Compile(m.Extension)
+	if err != nil {
+		return fmt.Errorf("invalid extension regex %q: %w", m.Extension, err)
+	}
+	*mime = Mime{Regex: regex, Type: m.Type}
+	return nil
+}
+
+type forbiddenJSON struct {
+	Type ForbiddenType `json:"type"`
+	Data string        `json:"data"`
+}
+
+// toForbiddenJSON creates the JSON of a matcher, also
+// used by rules matching paths like Forbidden
+func toForbiddenJSON(typ ForbiddenType, data string, regex *regexp.Regexp) forbiddenJSON {
+	if typ == Regex {
+		data = regex.String()
+	}
+	return forbiddenJSON{Type: typ, Data: data}
+}
+
+func (forbidden Forbidden) MarshalJSON() ([]byte, error) {
+	return json.Marshal(toForbiddenJSON(forbidden.Type, forbidden.Data, forbidden.Regex))
+}
+
+func (forbidden *Forbidden) UnmarshalJSON(data []byte) error {
+	var f forbiddenJSON
+	if err := json.Unmarshal(data, &f); err != nil {
+		return err
+	}
+	return forbidden.fromJSON(f)
+}
+
+func (forbidden *Forbidden) fromJSON(f forbiddenJSON) error {
+	switch f.Type {
+	case Regex:
+		regex, err := regexp.Compile(f.Data)
+		if err != nil {
+			return fmt.Errorf("invalid forbidden regex %q: %w", f.Data, err)
+		}
+		*forbidden = Forbidden{Regex: regex, Type: Regex}
+	case FileExtension, AbsoluteFile, AbsoluteDirectory:
+		*forbidden = Forbidden{Data: f.Data, Type: f.Type}
+	default:
+		return fmt.Errorf("invalid forbidden type %q", f.Type)
+	}
+	return nil
+}
+
+type cacheRuleJSON struct {
+	forbiddenJSON
+	MaxAge               *int   `json:"maxAge,omitempty"`
+	StaleWhileRevalidate *int   `json:"staleWhileRevalidate,omitempty"`
+	Immutable            bool   `json:"immutable"`
+	NoStore              bool   `json:"noStore"`
+	Extra                string `json:"extra"`
+}
+
+func (rule CacheRule) MarshalJSON() ([]byte, error) {
+	return json.Marshal(cacheRuleJSON{
+		forbiddenJSON:        toForbiddenJSON(rule.Type, rule.Data, rule.Regex),
+		MaxAge:               rule.MaxAge,
+		StaleWhileRevalidate: rule.StaleWhileRevalidate,
+		Immutable:            rule.Immutable,
+		NoStore:              rule.NoStore,
+		Extra:                rule.Extra,
+	})
+}
+
+func (rule *CacheRule) UnmarshalJSON(data []byte) error {
+	var c cacheRuleJSON
+	if err := json.Unmarshal(data, &c); err != nil {
+		return err
+	}
+	var matcher Forbidden
+	if err := matcher.fromJSON(c.forbiddenJSON); err != nil {
+		return err
+	}
+	*rule = CacheRule{
+		Data:                 matcher.Data,
+		Regex:                matcher.Regex,
+		Type:                 matcher.Type,
+		MaxAge:               c.MaxAge,
+		StaleWhileRevalidate: c.StaleWhileRevalidate,
+		Immutable:            c.Immutable,
+		NoStore:              c.NoStore,
+		Extra:                c.Extra,
+	}
+	return nil
+}
+
+type redirectJSON struct {
+	forbiddenJSON
+	Target string `json:"target"`
+	Code   int    `json:"code"`
+}
+
+func (redirect Redirect) MarshalJSON() ([]byte, error) {
+	return json.Marshal(redirectJSON{
+		forbiddenJSON: toForbiddenJSON(redirect.Type, redirect.Data, redirect.Regex),
+		Target:        redirect.Target,
+		Code:          redirect.Code,
+	})
+}
+
+func (redirect *Redirect) UnmarshalJSON(data []byte) error {
+	var r redirectJSON
+	if err := json.Unmarshal(data, &r); err != nil {
+		return err
+	}
+	var matcher Forbidden
+	if err := matcher.fromJSON(r.forbiddenJSON); err != nil {
+		return err
+	}
+	valid := false
+	for _, code := range RedirectCodes {
+		valid = valid || r.Code == code
+	}
+	if !valid {
+		return fmt.Errorf("invalid redirect code %d", r.Code)
+	}
+	*redirect = Redirect{
+		Data:   matcher.Data,
+		Regex:  matcher.Regex,
+		Type:   matcher.Type,
+		Target: r.Target,
+		Code:   r.Code,
+	}
+	return nil
+}
+
+type headerRuleJSON struct {
+	forbiddenJSON
+	Mimetype string       `json:"mimetype"`
+	Host     string       `json:"host"`
+	Action   HeaderAction `json:"action"`
+	Name     string       `json:"name"`
+	Value    string       `json:"value"`
+}
+
+func (rule HeaderRule) MarshalJSON() ([]byte, error) {
+	return json.Marshal(headerRuleJSON{
+		forbiddenJSON: toForbiddenJSON(rule.Type, rule.Data, rule.Regex),
+		Mimetype:      rule.Mimetype,
+		Host:          rule.Host,
+		Action:        rule.Action,
+		Name:          rule.Name,
+		Value:         rule.Value,
+	})
+}
+
+func (rule *HeaderRule) UnmarshalJSON(data []byte) error {
+	var h headerRuleJSON
+	if err := json.Unmarshal(data, &h); err != nil {
+		return err
+	}
+	// an empty type matches every path
+	var matcher Forbidden
+	if h.Type != "" {
+		if err := matcher.fromJSON(h.forbiddenJSON); err != nil {
+			return err
+		}
+	}
+	switch h.Action {
+	case HeaderSet, HeaderAppend, HeaderRemove:
+	default:
+		return fmt.Errorf("invalid header action %q", h.Action)
+	}
+	if h.Name == "" {
+		return fmt.Errorf("missing header name")
+	}
+	*rule = HeaderRule{
+		Data:     matcher.Data,
+		Regex:    matcher.Regex,
+		Type:     matcher.Type,
+		Mimetype: h.Mimetype,
+		Host:     strings.ToLower(h.Host),
+		Action:   h.Action,
+		Name:     h.Name,
+		Value:    h.Value,
+	}
+	return nil
+}
+
+// corsPolicyJSON is a CorsPolicy without its UnmarshalJSON
+type corsPolicyJSON CorsPolicy
+
+func (policy *CorsPolicy) UnmarshalJSON(data []byte) error {
+	var p corsPolicyJSON
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	// every origin would get reflected with credentials
+	if p.Credentials {
+		for _, origin := range p.Origins {
+			if origin == "*" {
+				return fmt.Errorf("origin \"*\" can't be used with credentials (%s)", p.Path)
+			}
+		}
+	}
+	*policy = CorsPolicy(p)
+	return nil
+}
@@ -1,11 +1,15 @@
 package settings
 
 import (
+	"errors"
 	"fmt"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/gocql/gocql"
+
 	"server/src"
 	"server/src/log"
 )
@@ -26,6 +30,29 @@ type Forbidden struct {
 	Type  ForbiddenType
 }
 
+// Matches checks if path is forbidden by the rule
+func (forbidden Forbidden) Matches(path string) bool {
+	switch forbidden.Type {
+	case FileExtension:
+		return strings.HasSuffix(path, "."+forbidden.Data)
+	case AbsoluteFile:
+		return path == forbidden.Data
+	case AbsoluteDirectory:
+		return strings.HasPrefix(path, forbidden.Data)
+	case Regex:
+		return forbidden.Regex.MatchString(path)
+	}
+	return false
+}
+
+// HasPathPrefix checks if path is inside the directory prefix, only
+// whole segments match, "/pub" and "/pub/" match "/pub" and "/pub/file"
+// but not "/public"
+func HasPathPrefix(path string, prefix string) bool {
+	dir := strings.TrimSuffix(prefix, "/")
+	return path == dir || path == prefix || strings.HasPrefix(path, dir+"/")
+}
+
 type ForbiddenType string
 
 const (
@@ -35,6 +62,205 @@ const (
 	AbsoluteDirectory ForbiddenType = "ad"
 )
 
+// CacheRule sets the Cache-Control header of responses
+// for paths matching the rule
+//
+// Type uses the same matchers as Forbidden, FileExtension, AbsoluteFile
+// and AbsoluteDirectory (prefix) store their information inside Data,
+// Regex stores inside Regex
+type CacheRule struct {
+	Data  string
+	Regex *regexp.Regexp
+	Type  ForbiddenType
+
+	// max-age in seconds, nil to not send it
+	MaxAge *int
+
+	// stale-while-revalidate in seconds, nil to not send it
+	StaleWhileRevalidate *int
+
+	Immutable bool
+	NoStore   bool
+
+	// Extra directives appended as they are, e.g. "public, must-revalidate"
+	Extra string
+}
+
+// Matches checks if path matches the rule
+func (rule CacheRule) Matches(path string) bool {
+	return Forbidden{Data: rule.Data, Regex: rule.Regex, Type: rule.Type}.Matches(path)
+}
+
+// CacheControl returns the value of the Cache-Control header
+func (rule CacheRule) CacheControl() string {
+	var directives []string
+	if rule.NoStore {
+		directives = append(directives, "no-store")
+	}
+	if rule.MaxAge != nil {
+		directives = append(directives, fmt.Sprintf("max-age=%d", *rule.MaxAge))
+	}
+	if rule.StaleWhileRevalidate != nil {
+		directives = append(directives, fmt.Sprintf("stale-while-revalidate=%d", *rule.StaleWhileRevalidate))
+	}
+	if rule.Immutable {
+		directives = append(directives, "immutable")
+	}
+	if rule.Extra != "" {
+		directives = append(directives, rule.Extra)
+	}
+	return strings.Join(directives, ", ")
+}
+
+// Redirect rule which redirects requests for matching paths
+// to Target or rewrites them internally to Target
+//
+// Type uses the same matchers as Forbidden, AbsoluteFile matches
+// exact, AbsoluteDirectory the directory and the paths inside it
+// and appends the rest of the path after a "/" to Target, Regex
+// replaces $1, ${name}, ... inside Target with the capture groups of Regex
+type Redirect struct {
+	Data  string
+	Regex *regexp.Regexp
+	Type  ForbiddenType
+
+	// Target path or URL
+	Target string
+
+	// Code of the redirect (301, 302, 307 or 308),
+	// 0 rewrites the request internally to Target
+	Code int
+}
+
+// Apply returns the target for path, false if the rule doesn't match
+func (redirect Redirect) Apply(path string) (string, bool) {
+	switch redirect.Type {
+	case AbsoluteDirectory:
+		if !HasPathPrefix(path, redirect.Data) {
+			break
+		}
+		dir := strings.TrimSuffix(redirect.Data, "/")
+		if len(path) <= len(dir)+1 {
+			return redirect.Target, true
+		}
+		return strings.TrimSuffix(redirect.Target, "/") + "/" + path[len(dir)+1:], true
+	case Regex:
+		if match := redirect.Regex.FindStringSubmatchIndex(path); match != nil {
+			return string(redirect.Regex.ExpandString(nil, redirect.Target, path, match)), true
+		}
+	default:
+		if (Forbidden{Data: redirect.Data, Type: redirect.Type}).Matches(path) {
+			return redirect.Target, true
+		}
+	}
+	return "", false
+}
+
+// RedirectCodes are the status codes a Redirect can have, 0 is a rewrite
+var RedirectCodes = []int{0, 301, 302, 307, 308}
+
+// CorsPolicy allows cross-origin requests for paths
+// starting with Path
+type CorsPolicy struct {
+	Path string `json:"path"`
+
+	// Origins allowed to access the paths, "*" allows every origin
+	// if Credentials is false, "https://*.example.com" every
+	// subdomain of example.com
+	Origins []string `json:"origins"`
+
+	// Methods allowed for cross-origin requests,
+	// if empty the AllowedMethods of the path are used
+	Methods []string `json:"methods"`
+
+	// Headers allowed in cross-origin requests,
+	// "*" allows every header if Credentials is false
+	Headers []string `json:"headers"`
+
+	// Credentials allows requests with cookies or authentication
+	Credentials bool `json:"credentials"`
+
+	// MaxAge in seconds preflight responses may be cached, 0 to not send it
+	MaxAge int `json:"maxAge"`
+}
+
+// SpaFallback serves Document for paths starting with Path
+// which don't exist, so single-page applications can route them
+//
+// paths with a file extension in their last segment (e.g.
+// /app/main.js) are assets and still get a 404 if they don't exist
+type SpaFallback struct {
+	Path string `json:"path"`
+
+	// Document served with 200 instead of the 404 (e.g. /app/index.html)
+	Document string `json:"document"`
+}
+
+// AuthRealm protects paths starting with Path with
+// HTTP Basic authentication of the users of Realm
+type AuthRealm struct {
+	Path string `json:"path"`
+
+	// Realm the users belong to, sent to clients in WWW-Authenticate
+	Realm string `json:"realm"`
+}
+
+// HeaderRule sets, appends or removes a response header for
+// responses matching the path, mimetype and host of the rule
+//
+// the path uses the same matchers as Forbidden, an empty Type
+// matches every path, an empty Mimetype or Host every mimetype or host
+type HeaderRule struct {
+	Data  string
+	Regex *regexp.Regexp
+	Type  ForbiddenType
+
+	// Mimetype the Content-Type has to start with (e.g. "text/")
+	Mimetype string
+
+	// Host of the request, "*.example.com" matches all subdomains
+	Host string
+
+	Action HeaderAction
+
+	// Name of the header
+	Name string
+
+	// Value to set or append, unused for HeaderRemove
+	Value string
+}
+
+type HeaderAction string
+
+const (
+	HeaderSet    HeaderAction = "set"
+	HeaderAppend HeaderAction = "append"
+	HeaderRemove HeaderAction = "remove"
+)
+
+// Matches checks if the rule applies to a response
+// of mimetype for path requested from host
+func (rule HeaderRule) Matches(path, mimetype, host string) bool {
+	if rule.Type != "" && !(Forbidden{Data: rule.Data, Regex: rule.Regex, Type: rule.Type}).Matches(path) {
+		return false
+	}
+	if !strings.HasPrefix(mimetype, rule.Mimetype) {
+		return false
+	}
+	if rule.Host == "" || rule.Host == host {
+		return true
+	}
+	i := strings.IndexByte(host, '.')
+	return strings.HasPrefix(rule.Host, "*.") && i > 0 && rule.Host[1:] == host[i:]
+}
+
+// AllowedMethods stores which request methods are
+// allowed for paths starting with Path
+type AllowedMethods struct {
+	Path    string   `json:"path"`
+	Methods []string `json:"methods"`
+}
+
 func LoadMimetypes() error {
 	now := time.Now()
 
@@ -408,3 +634,343 @@ func LoadMaxURILength() error {
 	log.Debug("Loaded MaxURILength in", time.Since(now))
 	return nil
 }
+
+func LoadAllowedMethods() error {
+	now := time.Now()
+
+	//language=SQL
+	sess := src.Session.Query(
+		"SELECT \"index\", path, methods FROM server.methods",
+	)
+	iter := sess.Iter()
+	sett.AllowedMethods.data = make([]AllowedMethods, iter.NumRows())
+	for {
+		row := make(map[string]any)
+		if !iter.MapScan(row) {
+			break
+		}
+		index, _ := strconv.Atoi(fmt.Sprintf("%d", row["index"]))
+		methods, _ := row["methods"].([]string)
+		for i := range methods {
+			methods[i] = strings.ToUpper(methods[i])
+		}
+		sett.AllowedMethods.data[index] = AllowedMethods{
+			Path:    fmt.Sprintf("%s", row["path"]),
+			Methods: methods,
+		}
+	}
+	if err := iter.Close(); err != nil {
+		log.Err(err, "Error loading AllowedMethods from DB")
+		log.Debug(iter.Warnings())
+		log.Debug(fmt.Sprintf("%s, attempts %d, latency: %dns", sess.String(), sess.Attempts(), sess.Latency()))
+		return err
+	}
+	log.Debug("Loaded AllowedMethods in", time.Since(now))
+	return nil
+}
+
+func LoadCacheRules() error {
+	now := time.Now()
+
+	//language=SQL
+	sess := src.Session.Query(
+		"SELECT \"index\", type, data, max_age, stale_while_revalidate, immutable, no_store, extra FROM server.cache_rules",
+	)
+	iter := sess.Iter()
+	sett.CacheRules.data = make([]CacheRule, iter.NumRows())
+	var (
+		index     int
+		typ, data string
+		rule      CacheRule
+	)
+	for iter.Scan(&index, &typ, &data, &rule.MaxAge, &rule.StaleWhileRevalidate, &rule.Immutable, &rule.NoStore, &rule.Extra) {
+		rule.Type = ForbiddenType(typ)
+		if rule.Type == Regex {
+			rule.Regex = regexp.MustCompile(data)
+		} else {
+			rule.Data = data
+		}
+		sett.CacheRules.data[index] = rule
+		rule = CacheRule{}
+	}
+	if err := iter.Close(); err != nil {
+		log.Err(err, "Error loading CacheRules from DB")
+		log.Debug(iter.Warnings())
+		log.Debug(fmt.Sprintf("%s, attempts %d, latency: %dns", sess.String(), sess.Attempts(), sess.Latency()))
+		return err
+	}
+	log.Debug("Loaded CacheRules in", time.Since(now))
+	return nil
+}
+
+func LoadIndexFiles() error {
+	now := time.Now()
+	name := "IndexFiles"
+
+	//language=SQL
+	sess := src.Session.Query(
+		"SELECT name, value FROM server.settings WHERE name=?", name,
+	)
+	setting := map[string]any{}
+	err := sess.MapScan(setting)
+	if errors.Is(err, gocql.ErrNotFound) {
+		// not stored yet, keep the default instead of querying on every request
+		sett.IndexFiles.data = sett.IndexFiles.defaultData
+		log.Debug("IndexFiles not stored, using default")
+		return nil
+	}
+	if err != nil {
+		log.Err(err, "Error loading IndexFiles from DB")
+		log.Debug(fmt.Sprintf("%s, attempts %d, latency: %dns", sess.String(), sess.Attempts(), sess.Latency()))
+		return err
+	}
+	// stored comma separated
+	sett.IndexFiles.data = []string{}
+	for _, file := range strings.Split(fmt.Sprintf("%s", setting["value"]), ",") {
+		if file = strings.TrimSpace(file); file != "" {
+			sett.IndexFiles.data = append(sett.IndexFiles.data, file)
+		}
+	}
+
+	log.Debug("Loaded IndexFiles in", time.Since(now))
+	return nil
+}
+
+func LoadAutoindex() error {
+	now := time.Now()
+
+	//language=SQL
+	sess := src.Session.Query(
+		"SELECT \"index\", path FROM server.autoindex",
+	)
+	iter := sess.Iter()
+	sett.Autoindex.data = make([]string, iter.NumRows())
+	for {
+		row := make(map[string]any)
+		if !iter.MapScan(row) {
+			break
+		}
+		index, _ := strconv.Atoi(fmt.Sprintf("%d", row["index"]))
+		sett.Autoindex.data[index] = fmt.Sprintf("%s", row["path"])
+	}
+	if err := iter.Close(); err != nil {
+		log.Err(err, "Error loading Autoindex from DB")
+		log.Debug(iter.Warnings())
+		log.Debug(fmt.Sprintf("%s, attempts %d, latency: %dns", sess.String(), sess.Attempts(), sess.Latency()))
+		return err
+	}
+	log.Debug("Loaded Autoindex in", time.Since(now))
+	return nil
+}
+
+func LoadRedirects() error {
+	now := time.Now()
+
+	//language=SQL
+	sess := src.Session.Query(
+		"SELECT \"index\", type, data, target, code FROM server.redirects",
+	)
+	iter := sess.Iter()
+	sett.Redirects.data = make([]Redirect, iter.NumRows())
+	for {
+		row := make(map[string]any)
+		if !iter.MapScan(row) {
+			break
+		}
+		index, _ := strconv.Atoi(fmt.Sprintf("%d", row["index"]))
+		code, _ := strconv.Atoi(fmt.Sprintf("%d", row["code"]))
+		redirect := Redirect{
+			Type:   ForbiddenType(fmt.Sprintf("%s", row["type"])),
+			Target: fmt.Sprintf("%s", row["target"]),
+			Code:   code,
+		}
+		if redirect.Type == Regex {
+			redirect.Regex = regexp.MustCompile(fmt.Sprintf("%s", row["data"]))
+		} else {
+			redirect.Data = fmt.Sprintf("%s", row["data"])
+		}
+		sett.Redirects.data[index] = redirect
+	}
+	if err := iter.Close(); err != nil {
+		log.Err(err, "Error loading Redirects from DB")
+		log.Debug(iter.Warnings())
+		log.Debug(fmt.Sprintf("%s, attempts %d, latency: %dns", sess.String(), sess.Attempts(), sess.Latency()))
+		return err
+	}
+	log.Debug("Loaded Redirects in", time.Since(now))
+	return nil
+}
+
+func LoadErrorPages() error {
+	now := time.Now()
+	name := "ErrorPages"
+
+	//language=SQL
+	sess := src.Session.Query(
+		"SELECT name, value FROM server.settings WHERE name=?", name,
+	)
+	setting := map[string]any{}
+	err := sess.MapScan(setting)
+	if errors.Is(err, gocql.ErrNotFound) {
+		// not stored yet, keep the default instead of querying on every request
+		sett.ErrorPages.data = sett.ErrorPages.defaultData
+		log.Debug("ErrorPages not stored, using default")
+		return nil
+	}
+	if err != nil {
+		log.Err(err, "Error loading ErrorPages from DB")
+		log.Debug(fmt.Sprintf("%s, attempts %d, latency: %dns", sess.String(), sess.Attempts(), sess.Latency()))
+		return err
+	}
+	sett.ErrorPages.data = fmt.Sprintf("%s", setting["value"])
+
+	log.Debug("Loaded ErrorPages in", time.Since(now))
+	return nil
+}
+
+func LoadHeaderRules() error {
+	now := time.Now()
+
+	//language=SQL
+	sess := src.Session.Query(
+		"SELECT \"index\", type, data, mimetype, host, action, name, value FROM server.header_rules",
+	)
+	iter := sess.Iter()
+	sett.HeaderRules.data = make([]HeaderRule, iter.NumRows())
+	for {
+		row := make(map[string]any)
+		if !iter.MapScan(row) {
+			break
+		}
+		index, _ := strconv.Atoi(fmt.Sprintf("%d", row["index"]))
+		rule := HeaderRule{
+			Type:     ForbiddenType(fmt.Sprintf("%s", row["type"])),
+			Mimetype: fmt.Sprintf("%s", row["mimetype"]),
+			Host:     strings.ToLower(fmt.Sprintf("%s", row["host"])),
+			Action:   HeaderAction(fmt.Sprintf("%s", row["action"])),
+			Name:     fmt.Sprintf("%s", row["name"]),
+			Value:    fmt.Sprintf("%s", row["value"]),
+		}
+		if rule.Type == Regex {
+			rule.Regex = regexp.MustCompile(fmt.Sprintf("%s", row["data"]))
+		} else {
+			rule.Data = fmt.Sprintf("%s", row["data"])
+		}
+		sett.HeaderRules.data[index] = rule
+	}
+	if err := iter.Close(); err != nil {
+		log.Err(err, "Error loading HeaderRules from DB")
+		log.Debug(iter.Warnings())
+		log.Debug(fmt.Sprintf("%s, attempts %d, latency: %dns", sess.String(), sess.Attempts(), sess.Latency()))
+		return err
+	}
+	log.Debug("Loaded HeaderRules in", time.Since(now))
+	return nil
+}
+
+func LoadCorsPolicies() error {
+	now := time.Now()
+
+	//language=SQL
+	sess := src.Session.Query(
+		"SELECT \"index\", path, origins, methods, headers, credentials, max_age FROM server.cors",
+	)
+	iter := sess.Iter()
+	sett.CorsPolicies.data = make([]CorsPolicy, iter.NumRows())
+	for {
+		row := make(map[string]any)
+		if !iter.MapScan(row) {
+			break
+		}
+		index, _ := strconv.Atoi(fmt.Sprintf("%d", row["index"]))
+		maxAge, _ := strconv.Atoi(fmt.Sprintf("%d", row["max_age"]))
+		origins, _ := row["origins"].([]string)
+		methods, _ := row["methods"].([]string)
+		headers, _ := row["headers"].([]string)
+		credentials, _ := row["credentials"].(bool)
+		for i := range methods {
+			methods[i] = strings.ToUpper(methods[i])
+		}
+		sett.CorsPolicies.data[index] = CorsPolicy{
+			Path:        fmt.Sprintf("%s", row["path"]),
+			Origins:     origins,
+			Methods:     methods,
+			Headers:     headers,
+			Credentials: credentials,
+			MaxAge:      maxAge,
+		}
+	}
+	if err := iter.Close(); err != nil {
+		log.Err(err, "Error loading CorsPolicies from DB")
+		log.Debug(iter.Warnings())
+		log.Debug(fmt.Sprintf("%s, attempts %d, latency: %dns", sess.String(), sess.Attempts(), sess.Latency()))
+		return err
+	}
+	log.Debug("Loaded CorsPolicies in", time.Since(now))
+	return nil
+}
+
+func LoadSpaFallbacks() error {
+	now := time.Now()
+
+	//language=SQL
+	sess := src.Session.Query(
+		"SELECT \"index\", path, document FROM server.spa_fallbacks",
+	)
+	iter := sess.Iter()
+	sett.SpaFallbacks.data = make([]SpaFallback, iter.NumRows())
+	for {
+		row := make(map[string]any)
+		if !iter.MapScan(row) {
+			break
+		}
+		index, _ := strconv.Atoi(fmt.Sprintf("%d", row["index"]))
+		sett.SpaFallbacks.data[index] = SpaFallback{
+			Path:     fmt.Sprintf("%s", row["path"]),
+			Document: fmt.Sprintf("%s", row["document"]),
+		}
+	}
+	if err := iter.Close(); err != nil {
+		log.Err(err, "Error loading SpaFallbacks from DB")
+		log.Debug(iter.Warnings())
+		log.Debug(fmt.Sprintf("%s, attempts %d, latency: %dns", sess.String(), sess.Attempts(), sess.Latency()))
+		return err
+	}
+	log.Debug("Loaded SpaFallbacks in", time.Since(now))
+	return nil
+}
+
+func LoadAuthRealms() error {
+	now := time.Now()
+
+	//language=SQL
+	sess := src.Session.Query(
+		"SELECT \"index\", path, realm FROM server.auth_realms",
+	)
+	iter := sess.Iter()
+	// only replaced once all rows were read, so a failed reload keeps the realms
+	realms := make([]AuthRealm, iter.NumRows())
+	for {
+		row := make(map[string]any)
+		if !iter.MapScan(row) {
+			break
+		}
+		index, _ := strconv.Atoi(fmt.Sprintf("%d", row["index"]))
+		if index < 0 || index >= len(realms) {
+			continue
+		}
+		realms[index] = AuthRealm{
+			Path:  fmt.Sprintf("%s", row["path"]),
+			Realm: fmt.Sprintf("%s", row["realm"]),
+		}
+	}
+	if err := iter.Close(); err != nil {
+		log.Err(err, "Error loading AuthRealms from DB")
+		log.Debug(iter.Warnings())
+		log.Debug(fmt.Sprintf("%s, attempts %d, latency: %dns", sess.String(), sess.Attempts(), sess.Latency()))
+		return err
+	}
+	sett.AuthRealms.data = realms
+	log.Debug("Loaded AuthRealms in", time.Since(now))
+	return nil
+}
@@ -1,7 +1,10 @@
 package settings
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"reflect"
 	"sync"
 	"time"
 
@@ -87,6 +90,71 @@ type settings struct {
 	//
 	// default: 1000
 	MaxURILength setting[uint16]
+
+	// List of paths with the request methods allowed
+	// for them, the first rule with a matching path prefix
+	// is used, if none matches GET, HEAD and OPTIONS are allowed
+	// files get served for every allowed method besides OPTIONS
+	//
+	// default []
+	AllowedMethods setting[[]AllowedMethods]
+
+	// List of rules setting the Cache-Control header,
+	// the first rule matching the path is used
+	//
+	// default []
+	CacheRules setting[[]CacheRule]
+
+	// files served for requests of a directory,
+	// the first one existing in the directory is used
+	//
+	// default: ["index.html", "index.htm"]
+	IndexFiles setting[[]string]
+
+	// List of path prefixes of directories which get
+	// a listing of their content if they have no index file
+	//
+	// default []
+	Autoindex setting[[]string]
+
+	// List of rules redirecting or internally rewriting
+	// requests, the first rule matching the path is used
+	//
+	// default []
+	Redirects setting[[]Redirect]
+
+	// directory inside the sites with error documents
+	// named by their status (e.g. /errors/404.html),
+	// empty to always use the built-in error site
+	//
+	// default: "/errors"
+	ErrorPages setting[string]
+
+	// List of rules setting, appending or removing response
+	// headers (e.g. security headers), all matching rules
+	// get applied in order
+	//
+	// default []
+	HeaderRules setting[[]HeaderRule]
+
+	// List of CORS policies, the first policy
+	// with a matching path prefix is used
+	//
+	// default []
+	CorsPolicies setting[[]CorsPolicy]
+
+	// List of fallback documents for single-page applications,
+	// the first fallback with a matching path prefix is used
+	//
+	// default []
+	SpaFallbacks setting[[]SpaFallback]
+
+	// List of realms requiring HTTP Basic authentication, the
+	// first realm with a matching path prefix is used, a realm
+	// on "/admin" protects "/admin/x" but not "/admin2"
+	//
+	// default nil, as long as no realms could get loaded
+	AuthRealms setting[[]AuthRealm]
 }
 
 type setting[T any] struct {
@@ -110,6 +178,23 @@ type setting[T any] struct {
 
 	// function to load Data from DB
 	loadFunc func() error
+
+	// function to store Data in DB, nil if setting can't be changed
+	storeFunc func(T) error
+
+	// data is returned without loading, see Override
+	overridden bool
+}
+
+// Setting is implemented by every setting, to access
+// settings without knowing their type (e.g. for the api)
+type Setting interface {
+	// Value returns the current value
+	Value() any
+
+	// Set stores the JSON encoded value in the DB
+	// and reloads the setting on next access
+	Set(data []byte) error
 }
 
 type LiveTime uint8
@@ -166,11 +251,24 @@ func GetSettings() *settings {
 	return &sett
 }
 
+// All returns all settings by their name
+func (settings *settings) All() map[string]Setting {
+	all := map[string]Setting{}
+	value := reflect.ValueOf(settings).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		if setting, ok := value.Field(i).Addr().Interface().(Setting); ok {
+			all[value.Type().Field(i).Name] = setting
+		}
+	}
+	return all
+}
+
 func LoadDefaultSettings() {
 	sett.DefaultSite = setting[string]{
 		defaultData: "/index.html",
 		liveTime:    LoadAsyncAfterEveryRequest,
 		loadFunc:    LoadDefaultSite,
+		storeFunc:   storeSetting[string]("DefaultSite"),
 	}
 	sett.Mimetypes = setting[[]Mime]{
 		defaultData: []Mime{},
@@ -178,12 +276,14 @@ func LoadDefaultSettings() {
 		liveTimeData: LoadAfterXTimeData{
 			XTime: 60 * time.Second,
 		},
-		loadFunc: LoadMimetypes,
+		loadFunc:  LoadMimetypes,
+		storeFunc: StoreMimetypes,
 	}
 	sett.ServerOff = setting[bool]{
 		defaultData: false,
 		liveTime:    LoadAsyncAfterEveryRequest,
 		loadFunc:    LoadServerOff,
+		storeFunc:   storeSetting[bool]("ServerOff"),
 	}
 	sett.DeflateCompressMinSize = setting[uint64]{
 		defaultData: 1400,
@@ -191,7 +291,8 @@ func LoadDefaultSettings() {
 		liveTimeData: LoadAfterXTimeData{
 			XTime: 60 * time.Second,
 		},
-		loadFunc: LoadDeflateCompressMinSize,
+		loadFunc:  LoadDeflateCompressMinSize,
+		storeFunc: storeSetting[uint64]("DeflateCompressMinSize"),
 	}
 	sett.GZipCompressMinSize = setting[uint64]{
 		defaultData: 1400,
@@ -199,7 +300,8 @@ func LoadDefaultSettings() {
 		liveTimeData: LoadAfterXTimeData{
 			XTime: 60 * time.Second,
 		},
-		loadFunc: LoadGZipCompressMinSize,
+		loadFunc:  LoadGZipCompressMinSize,
+		storeFunc: storeSetting[uint64]("GZipCompressMinSize"),
 	}
 	sett.BrotliCompressMinSize = setting[uint64]{
 		defaultData: 1400,
@@ -207,7 +309,8 @@ func LoadDefaultSettings() {
 		liveTimeData: LoadAfterXTimeData{
 			XTime: 60 * time.Second,
 		},
-		loadFunc: LoadBrotliCompressMinSize,
+		loadFunc:  LoadBrotliCompressMinSize,
+		storeFunc: storeSetting[uint64]("BrotliCompressMinSize"),
 	}
 	sett.DeflateCompressMinCompression = setting[float32]{
 		defaultData: 0.2,
@@ -215,7 +318,8 @@ func LoadDefaultSettings() {
 		liveTimeData: LoadAfterXTimeData{
 			XTime: 60 * time.Second,
 		},
-		loadFunc: LoadDeflateCompressMinCompression,
+		loadFunc:  LoadDeflateCompressMinCompression,
+		storeFunc: storeSetting[float32]("DeflateCompressMinCompression"),
 	}
 	sett.GZipCompressMinCompression = setting[float32]{
 		defaultData: 0.2,
@@ -223,7 +327,8 @@ func LoadDefaultSettings() {
 		liveTimeData: LoadAfterXTimeData{
 			XTime: 60 * time.Second,
 		},
-		loadFunc: LoadGZipCompressMinCompression,
+		loadFunc:  LoadGZipCompressMinCompression,
+		storeFunc: storeSetting[float32]("GZipCompressMinCompression"),
 	}
 	sett.BrotliCompressMinCompression = setting[float32]{
 		defaultData: 0.2,
@@ -231,7 +336,8 @@ func LoadDefaultSettings() {
 		liveTimeData: LoadAfterXTimeData{
 			XTime: 60 * time.Second,
 		},
-		loadFunc: LoadBrotliCompressMinCompression,
+		loadFunc:  LoadBrotliCompressMinCompression,
+		storeFunc: storeSetting[float32]("BrotliCompressMinCompression"),
 	}
 	sett.EnableDeflateCompression = setting[bool]{
 		defaultData: false,
@@ -239,7 +345,8 @@ func LoadDefaultSettings() {
 		liveTimeData: LoadAfterXTimeData{
 			XTime: 60 * time.Second,
 		},
-		loadFunc: LoadEnableDeflateCompression,
+		loadFunc:  LoadEnableDeflateCompression,
+		storeFunc: storeSetting[bool]("EnableDeflateCompression"),
 	}
 	sett.EnableGZipCompression = setting[bool]{
 		defaultData: true,
@@ -247,7 +354,8 @@ func LoadDefaultSettings() {
 		liveTimeData: LoadAfterXTimeData{
 			XTime: 60 * time.Second,
 		},
-		loadFunc: LoadEnableGZipCompression,
+		loadFunc:  LoadEnableGZipCompression,
+		storeFunc: storeSetting[bool]("EnableGZipCompression"),
 	}
 	sett.EnableBrotliCompression = setting[bool]{
 		defaultData: true,
@@ -255,7 +363,8 @@ func LoadDefaultSettings() {
 		liveTimeData: LoadAfterXTimeData{
 			XTime: 60 * time.Second,
 		},
-		loadFunc: LoadEnableBrotliCompression,
+		loadFunc:  LoadEnableBrotliCompression,
+		storeFunc: storeSetting[bool]("EnableBrotliCompression"),
 	}
 	sett.MaxURILength = setting[uint16]{
 		defaultData: 1000,
@@ -263,7 +372,8 @@ func LoadDefaultSettings() {
 		liveTimeData: LoadAfterXRequestsData{
 			XRequests: 100,
 		},
-		loadFunc: LoadMaxURILength,
+		loadFunc:  LoadMaxURILength,
+		storeFunc: storeSetting[uint16]("MaxURILength"),
 	}
 	sett.Forbidden = setting[[]Forbidden]{
 		defaultData: []Forbidden{},
@@ -271,7 +381,99 @@ func LoadDefaultSettings() {
 		liveTimeData: LoadAfterXTimeData{
 			XTime: 30 * time.Second,
 		},
-		loadFunc: LoadForbidden,
+		loadFunc:  LoadForbidden,
+		storeFunc: StoreForbidden,
+	}
+	sett.AllowedMethods = setting[[]AllowedMethods]{
+		defaultData: []AllowedMethods{},
+		liveTime:    LoadAfterXTimeAfterAccess,
+		liveTimeData: LoadAfterXTimeData{
+			XTime: 30 * time.Second,
+		},
+		loadFunc:  LoadAllowedMethods,
+		storeFunc: StoreAllowedMethods,
+	}
+	sett.CacheRules = setting[[]CacheRule]{
+		defaultData: []CacheRule{},
+		liveTime:    LoadAfterXTimeAfterAccess,
+		liveTimeData: LoadAfterXTimeData{
+			XTime: 30 * time.Second,
+		},
+		loadFunc:  LoadCacheRules,
+		storeFunc: StoreCacheRules,
+	}
+	sett.IndexFiles = setting[[]string]{
+		defaultData: []string{"index.html", "index.htm"},
+		liveTime:    LoadAfterXTime,
+		liveTimeData: LoadAfterXTimeData{
+			XTime: 60 * time.Second,
+		},
+		loadFunc:  LoadIndexFiles,
+		storeFunc: StoreIndexFiles,
+	}
+	sett.Autoindex = setting[[]string]{
+		defaultData: []string{},
+		liveTime:    LoadAfterXTimeAfterAccess,
+		liveTimeData: LoadAfterXTimeData{
+			XTime: 30 * time.Second,
+		},
+		loadFunc:  LoadAutoindex,
+		storeFunc: StoreAutoindex,
+	}
+	sett.Redirects = setting[[]Redirect]{
+		defaultData: []Redirect{},
+		liveTime:    LoadAfterXTimeAfterAccess,
+		liveTimeData: LoadAfterXTimeData{
+			XTime: 30 * time.Second,
+		},
+		loadFunc:  LoadRedirects,
+		storeFunc: StoreRedirects,
+	}
+	sett.ErrorPages = setting[string]{
+		defaultData: "/errors",
+		liveTime:    LoadAfterXTime,
+		liveTimeData: LoadAfterXTimeData{
+			XTime: 60 * time.Second,
+		},
+		loadFunc:  LoadErrorPages,
+		storeFunc: storeSetting[string]("ErrorPages"),
+	}
+	sett.HeaderRules = setting[[]HeaderRule]{
+		defaultData: []HeaderRule{},
+		liveTime:    LoadAfterXTimeAfterAccess,
+		liveTimeData: LoadAfterXTimeData{
+			XTime: 30 * time.Second,
+		},
+		loadFunc:  LoadHeaderRules,
+		storeFunc: StoreHeaderRules,
+	}
+	sett.CorsPolicies = setting[[]CorsPolicy]{
+		defaultData: []CorsPolicy{},
+		liveTime:    LoadAfterXTimeAfterAccess,
+		liveTimeData: LoadAfterXTimeData{
+			XTime: 30 * time.Second,
+		},
+		loadFunc:  LoadCorsPolicies,
+		storeFunc: StoreCorsPolicies,
+	}
+	sett.SpaFallbacks = setting[[]SpaFallback]{
+		defaultData: []SpaFallback{},
+		liveTime:    LoadAfterXTimeAfterAccess,
+		liveTimeData: LoadAfterXTimeData{
+			XTime: 30 * time.Second,
+		},
+		loadFunc:  LoadSpaFallbacks,
+		storeFunc: StoreSpaFallbacks,
+	}
+	sett.AuthRealms = setting[[]AuthRealm]{
+		// nil, so requests can fail closed until the realms got loaded
+		defaultData: nil,
+		liveTime:    LoadAfterXTimeAfterAccess,
+		liveTimeData: LoadAfterXTimeData{
+			XTime: 30 * time.Second,
+		},
+		loadFunc:  LoadAuthRealms,
+		storeFunc: StoreAuthRealms,
 	}
 }
 
@@ -279,6 +481,11 @@ func (setting *setting[T]) Get() T {
 	// block to check if loaded
 	setting.loading.RLock()
 
+	if setting.overridden {
+		defer setting.loading.RUnlock()
+		return setting.data
+	}
+
 	// only check if loaded after acquiring lock (so if setting was loaded in meantime this doesn't get called again
 	if !setting.loaded {
 
@@ -327,8 +534,7 @@ func (setting *setting[T]) Get() T {
 
 	err := setting.load()
 	if err != nil {
-		log.Err(err, fmt.Sprintf("Error loading Settings %#v using default data", setting))
-		return setting.defaultData
+		log.Err(err, fmt.Sprintf("Error reloading Settings %#v keeping previous data", setting))
 	}
 
 	// get lock to read data
@@ -385,8 +591,9 @@ func (setting *setting[T]) load() (err error) {
 			go func() {
 				setting.loading.Lock()
 				defer setting.loading.Unlock()
-				err = setting.loadFunc()
-				if err == nil {
+				if err := setting.loadFunc(); err != nil {
+					log.Err(err, fmt.Sprintf("Error loading Setting %#v async", setting))
+				} else {
 					data.lastAccess = time.Now()
 				}
 			}()
@@ -395,3 +602,47 @@ func (setting *setting[T]) load() (err error) {
 	}
 	return
 }
+
+// Override makes Get return data without using the DB until
+// restore gets called, which brings back the previous state
+// (e.g. for tests)
+func (setting *setting[T]) Override(data T) (restore func()) {
+	setting.loading.Lock()
+	defer setting.loading.Unlock()
+	previousData, previousOverridden := setting.data, setting.overridden
+	setting.data, setting.overridden = data, true
+	return func() {
+		setting.loading.Lock()
+		defer setting.loading.Unlock()
+		setting.data, setting.overridden = previousData, previousOverridden
+	}
+}
+
+func (setting *setting[T]) Value() any {
+	return setting.Get()
+}
+
+// ErrInvalidValue is returned by Set if the value doesn't fit the setting
+var ErrInvalidValue = errors.New("invalid value")
+
+// ErrReadOnly is returned by Set if the setting can't be changed
+var ErrReadOnly = errors.New("setting can't be changed")
+
+func (setting *setting[T]) Set(data []byte) error {
+	if setting.storeFunc == nil {
+		return ErrReadOnly
+	}
+	var value T
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidValue, err)
+	}
+
+	setting.loading.Lock()
+	defer setting.loading.Unlock()
+	if err := setting.storeFunc(value); err != nil {
+		return err
+	}
+	// load again on next access
+	setting.loaded = false
+	return nil
+}
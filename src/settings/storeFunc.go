@@ -0,0 +1,176 @@
+package settings
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gocql/gocql"
+
+	"server/src"
+	"server/src/log"
+)
+
+// storeSetting creates a function to store a value as name inside server.settings
+func storeSetting[T any](name string) func(T) error {
+	return func(value T) error {
+		//language=SQL
+		query := src.Session.Query(
+			"INSERT INTO server.settings (name, value) VALUES (?,?)", name, fmt.Sprintf("%v", value),
+		)
+		if err := query.Exec(); err != nil {
+			log.Err(err, fmt.Sprintf("Error storing %s in DB", name))
+			log.Debug(query.Context())
+			return err
+		}
+		log.Debug("Stored", name, value)
+		return nil
+	}
+}
+
+// storeRows replaces all rows of a table which has an "index" primary key
+// with rows, the index of every row is its position inside rows
+//
+// rows which are not overwritten get deleted in the same batch
+func storeRows(table string, columns []string, rows [][]any) error {
+	//language=SQL
+	sess := src.Session.Query(fmt.Sprintf("SELECT \"index\" FROM server.%s", table))
+	iter := sess.Iter()
+	var old []int
+	var index int
+	for iter.Scan(&index) {
+		old = append(old, index)
+	}
+	if err := iter.Close(); err != nil {
+		log.Err(err, fmt.Sprintf("Error loading %s from DB", table))
+		log.Debug(fmt.Sprintf("%s, attempts %d, latency: %dns", sess.String(), sess.Attempts(), sess.Latency()))
+		return err
+	}
+
+	batch := src.Session.NewBatch(gocql.LoggedBatch)
+	insert := fmt.Sprintf(
+		"INSERT INTO server.%s (\"index\", %s) VALUES (?%s)",
+		table, strings.Join(columns, ", "), strings.Repeat(",?", len(columns)),
+	)
+	for index, row := range rows {
+		batch.Query(insert, append([]any{index}, row...)...)
+	}
+	for _, index := range old {
+		if index >= len(rows) {
+			batch.Query(fmt.Sprintf("DELETE FROM server.%s WHERE \"index\"=?", table), index)
+		}
+	}
+	if err := src.Session.ExecuteBatch(batch); err != nil {
+		log.Err(err, fmt.Sprintf("Error storing %s in DB", table))
+		return err
+	}
+	log.Debug("Stored", table, len(rows))
+	return nil
+}
+
+func StoreMimetypes(mimetypes []Mime) error {
+	rows := make([][]any, len(mimetypes))
+	for i, mime := range mimetypes {
+		rows[i] = []any{mime.Regex.String(), mime.Type}
+	}
+	return storeRows("mime", []string{"extension", "mimetype"}, rows)
+}
+
+func StoreForbidden(forbidden []Forbidden) error {
+	rows := make([][]any, len(forbidden))
+	for i, rule := range forbidden {
+		data := rule.Data
+		if rule.Type == Regex {
+			data = rule.Regex.String()
+		}
+		rows[i] = []any{string(rule.Type), data}
+	}
+	return storeRows("forbidden", []string{"type", "data"}, rows)
+}
+
+func StoreAllowedMethods(allowedMethods []AllowedMethods) error {
+	rows := make([][]any, len(allowedMethods))
+	for i, rule := range allowedMethods {
+		methods := make([]string, len(rule.Methods))
+		for j, method := range rule.Methods {
+			methods[j] = strings.ToUpper(method)
+		}
+		rows[i] = []any{rule.Path, methods}
+	}
+	return storeRows("methods", []string{"path", "methods"}, rows)
+}
+
+func StoreCacheRules(cacheRules []CacheRule) error {
+	rows := make([][]any, len(cacheRules))
+	for i, rule := range cacheRules {
+		data := rule.Data
+		if rule.Type == Regex {
+			data = rule.Regex.String()
+		}
+		rows[i] = []any{string(rule.Type), data, rule.MaxAge, rule.StaleWhileRevalidate, rule.Immutable, rule.NoStore, rule.Extra}
+	}
+	return storeRows("cache_rules", []string{"type", "data", "max_age", "stale_while_revalidate", "immutable", "no_store", "extra"}, rows)
+}
+
+func StoreIndexFiles(files []string) error {
+	return storeSetting[string]("IndexFiles")(strings.Join(files, ","))
+}
+
+func StoreAutoindex(paths []string) error {
+	rows := make([][]any, len(paths))
+	for i, path := range paths {
+		rows[i] = []any{path}
+	}
+	return storeRows("autoindex", []string{"path"}, rows)
+}
+
+func StoreRedirects(redirects []Redirect) error {
+	rows := make([][]any, len(redirects))
+	for i, redirect := range redirects {
+		data := redirect.Data
+		if redirect.Type == Regex {
+			data = redirect.Regex.String()
+		}
+		rows[i] = []any{string(redirect.Type), data, redirect.Target, redirect.Code}
+	}
+	return storeRows("redirects", []string{"type", "data", "target", "code"}, rows)
+}
+
+func StoreHeaderRules(headerRules []HeaderRule) error {
+	rows := make([][]any, len(headerRules))
+	for i, rule := range headerRules {
+		data := rule.Data
+		if rule.Type == Regex {
+			data = rule.Regex.String()
+		}
+		rows[i] = []any{string(rule.Type), data, rule.Mimetype, strings.ToLower(rule.Host), string(rule.Action), rule.Name, rule.Value}
+	}
+	return storeRows("header_rules", []string{"type", "data", "mimetype", "host", "action", "name", "value"}, rows)
+}
+
+func StoreCorsPolicies(corsPolicies []CorsPolicy) error {
+	rows := make([][]any, len(corsPolicies))
+	for i, policy := range corsPolicies {
+		methods := make([]string, len(policy.Methods))
+		for j, method := range policy.Methods {
+			methods[j] = strings.ToUpper(method)
+		}
+		rows[i] = []any{policy.Path, policy.Origins, methods, policy.Headers, policy.Credentials, policy.MaxAge}
+	}
+	return storeRows("cors", []string{"path", "origins", "methods", "headers", "credentials", "max_age"}, rows)
+}
+
+func StoreSpaFallbacks(spaFallbacks []SpaFallback) error {
+	rows := make([][]any, len(spaFallbacks))
+	for i, fallback := range spaFallbacks {
+		rows[i] = []any{fallback.Path, fallback.Document}
+	}
+	return storeRows("spa_fallbacks", []string{"path", "document"}, rows)
+}
+
+func StoreAuthRealms(authRealms []AuthRealm) error {
+	rows := make([][]any, len(authRealms))
+	for i, realm := range authRealms {
+		rows[i] = []any{realm.Path, realm.Realm}
+	}
+	return storeRows("auth_realms", []string{"path", "realm"}, rows)
+}
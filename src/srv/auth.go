@@ -0,0 +1,72 @@
+package srv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"server/src/auth"
+	"server/src/settings"
+)
+
+type userKey struct{}
+
+// errNoRealms is returned while the realms were never loaded,
+// requests fail closed instead of passing unprotected
+var errNoRealms = errors.New("auth realms not loaded")
+
+// getAuthRealm returns the first settings.AuthRealms
+// realm with a path prefix matching url
+func getAuthRealm(url string) (settings.AuthRealm, bool, error) {
+	realms := settings.GetSettings().AuthRealms.Get()
+	if realms == nil {
+		return settings.AuthRealm{}, false, errNoRealms
+	}
+	for _, realm := range realms {
+		if settings.HasPathPrefix(url, realm.Path) {
+			return realm, true, nil
+		}
+	}
+	return settings.AuthRealm{}, false, nil
+}
+
+// authenticate checks the Basic credentials of request against the
+// users of the realm of its path and returns request with the user
+//
+// requests without valid credentials get 401 and the WWW-Authenticate
+// header set on header, paths without realm pass, if the
+// realms were never loaded every request gets 500
+func authenticate(request *http.Request, header http.Header) (*http.Request, Errors, error) {
+	realm, ok, err := getAuthRealm(request.URL.Path)
+	if err != nil {
+		return request, http.StatusInternalServerError, err
+	}
+	if !ok {
+		return request, 0, nil
+	}
+
+	name, password, ok := request.BasicAuth()
+	if ok {
+		valid, err := auth.Authenticate(realm.Realm, name, password)
+		if err != nil {
+			return request, http.StatusInternalServerError, err
+		}
+		if valid {
+			return request.WithContext(context.WithValue(request.Context(), userKey{}, name)), 0, nil
+		}
+	}
+
+	header.Set("WWW-Authenticate", fmt.Sprintf(`Basic realm="%s", charset="UTF-8"`, strings.NewReplacer(`"`, "", `\`, "").Replace(realm.Realm)))
+	if !ok {
+		return request, http.StatusUnauthorized, errors.New(fmt.Sprintf("no credentials for realm %s", realm.Realm))
+	}
+	return request, http.StatusUnauthorized, errors.New(fmt.Sprintf("invalid credentials of %s for realm %s", name, realm.Realm))
+}
+
+// getUser returns the authenticated user of request, "" if it has none
+func getUser(request *http.Request) string {
+	user, _ := request.Context().Value(userKey{}).(string)
+	return user
+}
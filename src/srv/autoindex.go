@@ -0,0 +1,107 @@
+package srv
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"server/src/settings"
+)
+
+// entry is a file or directory inside a listing
+type entry struct {
+	Name     string `json:"name"`
+	Dir      bool   `json:"dir"`
+	Size     int    `json:"size"`
+	Mimetype string `json:"mimetype"`
+}
+
+// media types listings can be served as, the first one is the default
+var listingTypes = []string{"text/html", "application/json"}
+
+// autoindexEnabled checks if path is inside a
+// directory prefix of settings.Autoindex
+func autoindexEnabled(path string) bool {
+	for _, prefix := range settings.GetSettings().Autoindex.Get() {
+		if settings.HasPathPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// listing returns the entries of directory at path, directories first,
+// entries forbidden by settings.Forbidden are left out
+func (directory dir) listing(path string) []entry {
+	forbidden := settings.GetSettings().Forbidden.Get()
+	hidden := func(path string) bool {
+		for _, rule := range forbidden {
+			if rule.Matches(path) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var dirs, files []entry
+	for name := range directory.dirs {
+		if !hidden(path + name + "/") {
+			dirs = append(dirs, entry{Name: name, Dir: true})
+		}
+	}
+	for name, file := range directory.files {
+		if !hidden(path + name) {
+			files = append(files, entry{Name: name, Size: len(file.data.raw), Mimetype: file.mimetype})
+		}
+	}
+	sort.Slice(dirs, func(i, j int) bool { return dirs[i].Name < dirs[j].Name })
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return append(dirs, files...)
+}
+
+// getListing renders the listing of directory as JSON
+// if the request prefers it over HTML, otherwise as HTML
+func getListing(request *http.Request, directory dir, path string) (*[]byte, string, error) {
+	entries := directory.listing(path)
+
+	if preferredType(request.Header.Values("Accept"), listingTypes) == "application/json" {
+		if entries == nil {
+			entries = []entry{}
+		}
+		data, err := json.Marshal(entries)
+		return &data, "application/json", err
+	}
+
+	var rows strings.Builder
+	if path != "/" {
+		rows.WriteString("\t\t\t<tr><td><a href=\"../\">../</a></td><td></td><td></td></tr>\n")
+	}
+	for _, e := range entries {
+		name, href, size := html.EscapeString(e.Name), url.PathEscape(e.Name), fmt.Sprintf("%d", e.Size)
+		if e.Dir {
+			name, href, size = name+"/", href+"/", "-"
+		}
+		rows.WriteString(fmt.Sprintf("\t\t\t<tr><td><a href=\"%s\">%s</a></td><td>%s</td><td>%s</td></tr>\n", href, name, size, html.EscapeString(e.Mimetype)))
+	}
+
+	site := []byte(fmt.Sprintf(`
+<html>
+	<head>
+		<meta charset="utf-8"/>
+		<meta name="viewport" content="width=device-width"/>
+		<title>Index of %s</title>
+	</head>
+	<body>
+		<h1>Index of %s</h1>
+		<table>
+			<tr><th>Name</th><th>Size</th><th>Mimetype</th></tr>
+%s		</table>
+	</body>
+</html>
+`, html.EscapeString(path), html.EscapeString(path), rows.String()))
+	return &site, "text/html; charset=utf-8", nil
+}
@@ -0,0 +1,38 @@
+package srv
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// notModified checks the conditional headers of request, true if the
+// client already has the current version and 304 can be sent
+//
+// If-None-Match takes precedence over If-Modified-Since
+func notModified(request *http.Request, etag string, modTime time.Time) bool {
+	if request.Method != http.MethodGet && request.Method != http.MethodHead {
+		return false
+	}
+
+	if ifNoneMatch := request.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		for _, tag := range strings.Split(ifNoneMatch, ",") {
+			tag = strings.TrimSpace(tag)
+			// If-None-Match uses weak comparison
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ifModifiedSince := request.Header.Get("If-Modified-Since"); ifModifiedSince != "" {
+		date, err := http.ParseTime(ifModifiedSince)
+		if err != nil {
+			return false
+		}
+		// Last-Modified only has a precision of seconds
+		return !modTime.Truncate(time.Second).After(date)
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package srv
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"server/src/settings"
+)
+
+// getCorsPolicy returns the first settings.CorsPolicies
+// policy with a path prefix matching url
+func getCorsPolicy(url string) (settings.CorsPolicy, bool) {
+	for _, policy := range settings.GetSettings().CorsPolicies.Get() {
+		if settings.HasPathPrefix(url, policy.Path) {
+			return policy, true
+		}
+	}
+	return settings.CorsPolicy{}, false
+}
+
+// applyCorsPolicy sets the CORS headers of the policy
+// of the path of request on header, if it has one
+func applyCorsPolicy(request *http.Request, header http.Header) {
+	if policy, ok := getCorsPolicy(request.URL.Path); ok {
+		header.Add("Vary", "Origin")
+		applyCors(policy, request, header, getAllowedMethods(request.URL.Path))
+	}
+}
+
+// isPreflight checks if request is a CORS preflight request
+func isPreflight(request *http.Request) bool {
+	return request.Method == http.MethodOptions &&
+		request.Header.Get("Origin") != "" &&
+		request.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// answerPreflight sets the CORS headers of the policy of request on header,
+// if request is a preflight of a path with a CORS policy, returns false
+// for other requests
+//
+// preflights get answered even if OPTIONS is not allowed,
+// without CORS headers if the request is not allowed
+func answerPreflight(request *http.Request, header http.Header) bool {
+	if !isPreflight(request) {
+		return false
+	}
+	policy, ok := getCorsPolicy(request.URL.Path)
+	if !ok {
+		return false
+	}
+	header.Add("Vary", "Origin")
+	header.Add("Vary", "Access-Control-Request-Method")
+	header.Add("Vary", "Access-Control-Request-Headers")
+	applyCors(policy, request, header, getAllowedMethods(request.URL.Path))
+	return true
+}
+
+// applyCors sets the CORS headers of policy for request on header,
+// requests not allowed by policy get no CORS headers
+//
+// methods are used if the policy has no methods
+func applyCors(policy settings.CorsPolicy, request *http.Request, header http.Header, methods []string) {
+	origin := request.Header.Get("Origin")
+	if origin == "" || !allowedOrigin(policy.Origins, origin, policy.Credentials) {
+		return
+	}
+
+	if isPreflight(request) {
+		if len(policy.Methods) > 0 {
+			methods = policy.Methods
+		}
+		if !contains(methods, request.Header.Get("Access-Control-Request-Method")) {
+			return
+		}
+		requested := splitList(request.Header.Values("Access-Control-Request-Headers"))
+		anyHeader := !policy.Credentials && contains(policy.Headers, "*")
+		for _, name := range requested {
+			if !anyHeader && !containsFold(policy.Headers, name) {
+				return
+			}
+		}
+		header.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		if len(requested) > 0 {
+			header.Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+		}
+		if policy.MaxAge > 0 {
+			header.Set("Access-Control-Max-Age", strconv.Itoa(policy.MaxAge))
+		}
+	}
+
+	if contains(policy.Origins, "*") && !policy.Credentials {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+	if policy.Credentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// allowedOrigin checks if origin matches one of origins,
+// which can contain a "*" as wildcard
+//
+// with credentials "*" matches no origin, as it would allow every site
+func allowedOrigin(origins []string, origin string, credentials bool) bool {
+	for _, allowed := range origins {
+		if allowed == "*" && credentials {
+			continue
+		}
+		before, after, wildcard := strings.Cut(allowed, "*")
+		if !wildcard {
+			if strings.EqualFold(allowed, origin) {
+				return true
+			}
+			continue
+		}
+		if len(origin) > len(before)+len(after) && strings.HasPrefix(origin, before) && strings.HasSuffix(origin, after) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitList splits comma separated header values
+func splitList(values []string) []string {
+	var list []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			if element = strings.TrimSpace(element); element != "" {
+				list = append(list, element)
+			}
+		}
+	}
+	return list
+}
+
+func containsFold(list []string, value string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package srv
+
+import (
+	"strconv"
+	"strings"
+)
+
+// identity is the name of the uncompressed Encoding inside Accept-Encoding,
+// the Encoding of uncompressed data itself is ""
+const identity = "identity"
+
+// acceptEncoding is a parsed Accept-Encoding header
+type acceptEncoding struct {
+	// qvalues of the listed codings, identity is stored as ""
+	qvalues map[Encoding]float64
+
+	// qvalue of "*", -1 if not listed
+	wildcard float64
+}
+
+// parseAcceptEncoding parses the values of all Accept-Encoding headers
+//
+// codings without qvalue get 1, codings with an invalid qvalue
+// get ignored, if a coding is listed multiple times the last one is used
+func parseAcceptEncoding(values []string) acceptEncoding {
+	accept := acceptEncoding{qvalues: map[Encoding]float64{}, wildcard: -1}
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			coding, params, _ := strings.Cut(element, ";")
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			if coding == "" {
+				continue
+			}
+			q, ok := parseQValue(params)
+			if !ok {
+				continue
+			}
+			switch coding {
+			case "*":
+				accept.wildcard = q
+			case identity:
+				accept.qvalues[""] = q
+			default:
+				accept.qvalues[Encoding(coding)] = q
+			}
+		}
+	}
+	return accept
+}
+
+// parseQValue returns the q parameter of params, 1 if not set
+func parseQValue(params string) (float64, bool) {
+	for _, param := range strings.Split(params, ";") {
+		name, value, _ := strings.Cut(param, "=")
+		if strings.ToLower(strings.TrimSpace(name)) != "q" {
+			continue
+		}
+		q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || q < 0 || q > 1 {
+			return 0, false
+		}
+		return q, true
+	}
+	return 1, true
+}
+
+// quality returns the qvalue of encoding, 0 means not acceptable
+//
+// codings not listed are only acceptable through "*",
+// identity is acceptable unless refused explicitly or by "*;q=0"
+func (accept acceptEncoding) quality(encoding Encoding) float64 {
+	if q, ok := accept.qvalues[encoding]; ok {
+		return q
+	}
+	if accept.wildcard >= 0 {
+		return accept.wildcard
+	}
+	if encoding == "" {
+		return 1
+	}
+	return 0
+}
@@ -1,20 +1,71 @@
 package srv
 
 import (
-	"bufio"
+	"encoding/json"
 	"fmt"
+	"html"
 	"net/http"
-	"os"
-	"runtime"
+	"strconv"
 	"strings"
+
+	"server/src/log"
+	"server/src/settings"
 )
 
 type Errors uint16
 
-func GetErrorSite(error Errors, host string, path string, additional string) (*[]byte, int) {
+// fallbackErrorSite is served for errors without an error document,
+// {{...}} placeholders get replaced like in error documents
+const fallbackErrorSite = `<!DOCTYPE html>
+<html>
+	<head>
+		<meta charset="utf-8"/>
+		<meta name="viewport" content="width=device-width"/>
+		<title>{{status}} | {{title}}</title>
+	</head>
+	<body style="font-family:sans-serif;max-width:40em;margin:10vh auto;padding:0 1em">
+		<h1>{{status}} {{title}}</h1>
+		<p>{{message}}</p>
+		<p>{{additional}}</p>
+		<hr>
+		<p><small>Error accessing {{path}}, request ID {{requestID}}</small></p>
+	</body>
+</html>
+`
+
+// problem is an error as application/problem+json (RFC 7807)
+type problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// media types error sites can be served as, the first one is the default
+var errorTypes = []string{"text/html", "application/problem+json", "application/json", "text/plain"}
+
+// GetErrorSite returns the error site for request, the mimetype
+// of the site is returned too
+//
+// requests preferring JSON get application/problem+json, requests
+// preferring text/plain get plain text, otherwise the HTML site is
+// the first existing of
+//   - the NotFound file of vhost for 404
+//   - the error document <settings.ErrorPages>/<status>.html of vhost
+//   - the fallback error site
+//
+// placeholders {{status}}, {{title}}, {{message}}, {{additional}}, {{path}},
+// {{host}} and {{requestID}} inside the site get replaced
+func GetErrorSite(error Errors, request *http.Request, vhost *virtualHost, additional string) (*[]byte, int, string) {
 	var site string
 
 	switch error {
+	case http.StatusBadRequest:
+		site = "The server could not understand your Request."
+	case http.StatusUnauthorized:
+		site = "You need to authenticate to access this URL."
 	case http.StatusForbidden:
 		site = "You are not allowed to access this URL."
 	case http.StatusNotFound:
@@ -23,54 +74,89 @@ func GetErrorSite(error Errors, host string, path string, additional string) (*[
 		site = "Method not allowed."
 	case http.StatusInternalServerError:
 		site = "An error happened while processing your Request."
+	case http.StatusNotAcceptable:
+		site = "No acceptable representation of the requested resource."
 	case http.StatusRequestURITooLong:
 		site = "Request URI exceeds max URI length"
+	case http.StatusRequestedRangeNotSatisfiable:
+		site = "Requested range is not satisfiable."
+	case http.StatusBadGateway:
+		site = "The upstream server returned an invalid response."
+	case http.StatusGatewayTimeout:
+		site = "The upstream server didn't respond in time."
+	case http.StatusServiceUnavailable:
+		site = "The server is currently unable to handle your Request."
 	default:
 		site = "Error not found"
 	}
 
-	site = fmt.Sprintf(`
-<html>
-	<head>
-		<meta charset="utf-8"/>
-		<meta name="viewport" content="width=device-width"/>
-		<title>%d | %s</title>
-	</head>
-	<body style="background:black;background:linear-gradient(140deg, rgb(7 10 15) 0%%, rgb(0,0,0) 50%%, rgb(7 9 10) 100%%);;color:white">
-		<div style="margin:auto;width:50%%;padding:10px;position:absolute;bottom:50%%;right:50%%;transform:translate(50%%,50%%);overflow:hidden;display:flex;flex-direction:column">
-			<div style="display:flex;align-items:center;justify-content:space-between;gap:2em">
-				<h1 style="margin-block:0.2em;flex-shrink:0">%s</h1>
-				<p>Error accessing %s</p>
-			</div>		
-			<div style="display:flex;align-items:center;justify-content:space-between;gap:2em">
-				<p>%s</p>
-				<p>%s</p>
-				<button style="padding:8px 16px;color:white;border:white 1px solid;background:transparent;cursor:pointer;border-radius:1em" onclick="location.reload()">Reload</button>
-			</div>
-			<img src="https://http.cat/%d" style="align-self:center;max-height:80vh">
-			<hr style="width:100%%">
-			<address>GoWebserver at %s running %s on %s</address>
-		</div>
-	</body>
-</html>
-`, error, http.StatusText(int(error)), http.StatusText(int(error)), path, site, additional, int(error), host, runtime.Version(), getOS())
+	switch preferredType(request.Header.Values("Accept"), errorTypes) {
+	case "application/problem+json", "application/json":
+		ret, err := json.Marshal(problem{
+			Type:      "about:blank",
+			Title:     http.StatusText(int(error)),
+			Status:    int(error),
+			Detail:    additional,
+			Instance:  request.URL.Path,
+			RequestID: getRequestID(request),
+		})
+		if err != nil {
+			log.Err(err, "Error creating problem json")
+		}
+		return &ret, int(error), "application/problem+json"
+	case "text/plain":
+		ret := []byte(fmt.Sprintf("%d %s\n%s\n", error, http.StatusText(int(error)), site))
+		if additional != "" {
+			ret = append(ret, additional+"\n"...)
+		}
+		return &ret, int(error), "text/plain; charset=utf-8"
+	}
 
-	ret := []byte(site)
+	replacer := strings.NewReplacer(
+		"{{status}}", strconv.Itoa(int(error)),
+		"{{title}}", html.EscapeString(http.StatusText(int(error))),
+		"{{message}}", html.EscapeString(site),
+		"{{additional}}", html.EscapeString(additional),
+		"{{path}}", html.EscapeString(request.URL.Path),
+		"{{host}}", html.EscapeString(request.Host),
+		"{{requestID}}", html.EscapeString(getRequestID(request)),
+	)
 
-	return &ret, int(error)
+	if file := vhost.errorDocument(error); file != nil {
+		ret := []byte(replacer.Replace(string(file.data.raw)))
+		return &ret, int(error), file.mimetype
+	}
+	ret := []byte(replacer.Replace(fallbackErrorSite))
+	return &ret, int(error), "text/html; charset=utf-8"
 }
 
-func getOS() string {
-	f, err := os.Open("/etc/os-release")
-	if err != nil {
-		return "Not UNIX"
+// errorDocument returns the file to serve for error, nil if vhost has
+// none, forbidden documents are skipped like missing ones
+func (vhost *virtualHost) errorDocument(error Errors) *file {
+	if error == http.StatusNotFound && vhost.NotFound != "" {
+		if file := vhost.allowedFile(vhost.NotFound); file != nil {
+			return file
+		}
 	}
-	defer f.Close()
-	s := bufio.NewScanner(f)
-	for s.Scan() {
-		if strings.HasPrefix(s.Text(), "NAME=") {
-			return strings.TrimSuffix(strings.TrimPrefix(s.Text(), "NAME=\""), "\"")
+	if dir := settings.GetSettings().ErrorPages.Get(); dir != "" {
+		path := fmt.Sprintf("%s/%d.html", strings.TrimSuffix(dir, "/"), error)
+		if file := vhost.allowedFile(path); file != nil {
+			return file
 		}
 	}
-	return ""
+	return nil
+}
+
+// allowedFile returns the file at path, nil if it
+// doesn't exist or is forbidden
+func (vhost *virtualHost) allowedFile(path string) *file {
+	file, _, _ := vhost.root.lookup(path)
+	if file == nil {
+		return nil
+	}
+	if _, err := getForbidden(path); err != nil {
+		log.Debug("Skipping forbidden error document:", err)
+		return nil
+	}
+	return file
 }
@@ -0,0 +1,200 @@
+package srv
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"server/src/config"
+	"server/src/fcgi"
+	"server/src/log"
+	"server/src/settings"
+)
+
+// gateway runs scripts of requests on a FastCGI responder
+type gateway struct {
+	config.FastCGI
+	client *fcgi.Client
+}
+
+// all gateways of config.FastCGI
+var gateways []*gateway
+
+// LoadGateways creates the gateways of config.FastCGI
+func LoadGateways() error {
+	gateways = nil
+	for _, fastCGI := range config.GetConfig().FastCGI {
+		if (fastCGI.Path == "") == (fastCGI.Extension == "") {
+			return fmt.Errorf("FastCGI gateway %s needs either a Path or an Extension", fastCGI.Address)
+		}
+		if fastCGI.Address == "" {
+			return fmt.Errorf("FastCGI gateway %s%s has no address", fastCGI.Path, fastCGI.Extension)
+		}
+		if fastCGI.Extension != "" {
+			fastCGI.Extension = "." + strings.TrimPrefix(fastCGI.Extension, ".")
+		}
+
+		client := &fcgi.Client{Network: "tcp", Address: fastCGI.Address, Timeout: time.Duration(fastCGI.Timeout) * time.Second}
+		if strings.HasPrefix(fastCGI.Address, "unix:") {
+			client.Network, client.Address = "unix", strings.TrimPrefix(fastCGI.Address, "unix:")
+		}
+		gateways = append(gateways, &gateway{FastCGI: fastCGI, client: client})
+		log.Log(fmt.Sprintf("Running %s%s on FastCGI responder %s", fastCGI.Path, fastCGI.Extension, fastCGI.Address))
+	}
+	return nil
+}
+
+// getGateway returns the first gateway matching path
+// and the name of the script path runs on it
+func getGateway(path string) (*gateway, string) {
+	for _, g := range gateways {
+		if g.Path != "" {
+			if !settings.HasPathPrefix(path, g.Path) {
+				continue
+			}
+			if g.Script != "" {
+				return g, strings.TrimSuffix(g.Path, "/")
+			}
+			return g, path
+		}
+		if script := scriptName(path, g.Extension); script != "" {
+			return g, script
+		}
+	}
+	return nil, ""
+}
+
+// scriptName returns path up to the first segment ending
+// with extension, "" if path contains no such segment
+func scriptName(path string, extension string) string {
+	for i := 0; i < len(path); {
+		j := strings.Index(path[i:], extension)
+		if j < 0 {
+			return ""
+		}
+		end := i + j + len(extension)
+		if end == len(path) || path[end] == '/' {
+			return path[:end]
+		}
+		i = end
+	}
+	return ""
+}
+
+// serve runs script for request on the responder of gateway and streams
+// the response, forbidden paths get 403, errors are answered with 502 or 504
+func (gateway *gateway) serve(w http.ResponseWriter, request *http.Request, vhost *virtualHost, script string) *proxyResult {
+	result := &proxyResult{request: request, vhost: vhost}
+	if additional, err := getForbidden(request.URL.Path); err != nil {
+		result.err = err
+		result.code = writeErrorSite(w, request, vhost, http.StatusForbidden, additional)
+		return result
+	}
+
+	result.start = time.Now()
+	response, err := gateway.client.Do(request.Context(), gateway.params(request, script), request.Body)
+	result.latency = time.Since(result.start)
+	if err != nil {
+		result.err = fmt.Errorf("error running %s on %s: %w", script, gateway.Address, err)
+		code := Errors(http.StatusBadGateway)
+		if isTimeout(err) {
+			code = http.StatusGatewayTimeout
+		}
+		result.code = writeErrorSite(w, request, vhost, code, "")
+		return result
+	}
+	defer response.Body.Close()
+
+	header := w.Header()
+	for name, values := range response.Header {
+		header[name] = values
+	}
+	applyCorsPolicy(request, header)
+	applyHeaderRules(header, request.URL.Path, header.Get("Content-Type"), hostname(request.Host))
+	result.code = response.StatusCode
+	w.WriteHeader(response.StatusCode)
+	if request.Method == http.MethodHead {
+		return result
+	}
+
+	// flush every read, so long running scripts get streamed
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := response.Body.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				result.err = fmt.Errorf("error writing response of %s: %w", script, err)
+				return result
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				result.err = fmt.Errorf("error reading response of %s: %w", script, err)
+			}
+			return result
+		}
+	}
+}
+
+// params returns the CGI environment of request running script
+func (gateway *gateway) params(request *http.Request, script string) map[string]string {
+	filename := gateway.Root + script
+	if gateway.Script != "" {
+		filename = gateway.Script
+	}
+	params := map[string]string{
+		"GATEWAY_INTERFACE": "CGI/1.1",
+		"SERVER_SOFTWARE":   "GoWebserver",
+		"SERVER_PROTOCOL":   request.Proto,
+		"SERVER_NAME":       hostname(request.Host),
+		"REQUEST_METHOD":    request.Method,
+		"REQUEST_URI":       request.RequestURI,
+		"REQUEST_SCHEME":    "http",
+		"QUERY_STRING":      request.URL.RawQuery,
+		"DOCUMENT_ROOT":     gateway.Root,
+		"DOCUMENT_URI":      request.URL.Path,
+		"SCRIPT_NAME":       script,
+		"SCRIPT_FILENAME":   filename,
+		"PATH_INFO":         strings.TrimPrefix(request.URL.Path, script),
+		"REQUEST_ID":        getRequestID(request),
+		"HTTP_HOST":         request.Host,
+	}
+	if request.TLS != nil {
+		params["HTTPS"] = "on"
+		params["REQUEST_SCHEME"] = "https"
+	}
+	if host, port, err := net.SplitHostPort(request.RemoteAddr); err == nil {
+		params["REMOTE_ADDR"], params["REMOTE_PORT"] = host, port
+	}
+	if addr, ok := request.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		if _, port, err := net.SplitHostPort(addr.String()); err == nil {
+			params["SERVER_PORT"] = port
+		}
+	}
+	// bodies without length (chunked) are streamed until the end of stdin
+	if request.ContentLength >= 0 {
+		params["CONTENT_LENGTH"] = strconv.FormatInt(request.ContentLength, 10)
+	}
+	params["CONTENT_TYPE"] = request.Header.Get("Content-Type")
+
+	for name, values := range request.Header {
+		// Proxy is not passed, as it would set HTTP_PROXY (httpoxy)
+		if name == "Proxy" || name == "Content-Type" || name == "Content-Length" {
+			continue
+		}
+		params["HTTP_"+strings.ToUpper(strings.ReplaceAll(name, "-", "_"))] = strings.Join(values, ", ")
+	}
+	for name, value := range gateway.Params {
+		params[name] = value
+	}
+	return params
+}
@@ -0,0 +1,142 @@
+package srv
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"server/src/config"
+	"server/src/settings"
+)
+
+// startResponder serves handler as FastCGI responder on a local listener
+func startResponder(t *testing.T, handler http.Handler) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go fcgi.Serve(ln, handler)
+	return ln.Addr().String()
+}
+
+// startGateway serves the gateway of a responder at address for /app/,
+// the settings and config used by gateways get restored after the test
+func startGateway(t *testing.T, address string, timeout uint16) *httptest.Server {
+	sett := settings.GetSettings()
+	t.Cleanup(sett.Forbidden.Override([]settings.Forbidden{}))
+	t.Cleanup(sett.HeaderRules.Override([]settings.HeaderRule{}))
+	t.Cleanup(sett.CorsPolicies.Override([]settings.CorsPolicy{}))
+
+	conf := config.GetConfig()
+	previousFastCGI, previousGateways := conf.FastCGI, gateways
+	t.Cleanup(func() {
+		conf.FastCGI, gateways = previousFastCGI, previousGateways
+	})
+	conf.FastCGI = []config.FastCGI{{Path: "/app/", Address: address, Timeout: timeout}}
+	if err := LoadGateways(); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gateway, script := getGateway(r.URL.Path)
+		if gateway == nil {
+			http.NotFound(w, r)
+			return
+		}
+		gateway.serve(w, r, &virtualHost{}, script)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func get(t *testing.T, url string) *http.Response {
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// plain text error sites, so no error documents get looked up
+	request.Header.Set("Accept", "text/plain")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { response.Body.Close() })
+	return response
+}
+
+func TestGatewayServe(t *testing.T) {
+	release := make(chan struct{})
+	address := startResponder(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Path", r.URL.Path)
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("first\n"))
+		w.(http.Flusher).Flush()
+		<-release
+		w.Write([]byte("second\n"))
+	}))
+	server := startGateway(t, address, 1)
+
+	response := get(t, server.URL+"/app/index.php")
+	if response.Status != "201 Created" {
+		t.Errorf("status %q, expected \"201 Created\"", response.Status)
+	}
+	if path := response.Header.Get("X-Path"); path != "/app/index.php" {
+		t.Errorf("X-Path %q, expected \"/app/index.php\"", path)
+	}
+	if response.Header.Get("Status") != "" {
+		t.Error("Status header of the responder got passed on")
+	}
+
+	// the first part has to arrive while the responder still runs
+	body := bufio.NewReader(response.Body)
+	if line, err := body.ReadString('\n'); err != nil || line != "first\n" {
+		t.Fatalf("first line %q (%v), expected \"first\\n\"", line, err)
+	}
+	close(release)
+	if line, err := body.ReadString('\n'); err != nil || line != "second\n" {
+		t.Fatalf("second line %q (%v), expected \"second\\n\"", line, err)
+	}
+}
+
+func TestGatewayErrors(t *testing.T) {
+	// address of a closed listener, connections get refused
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused := ln.Addr().String()
+	ln.Close()
+
+	slow := startResponder(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(2 * time.Second)
+	}))
+
+	for _, test := range []struct {
+		name    string
+		address string
+		status  int
+	}{
+		{"refused", refused, http.StatusBadGateway},
+		{"timeout", slow, http.StatusGatewayTimeout},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			server := startGateway(t, test.address, 1)
+			response := get(t, server.URL+"/app/index.php")
+			if response.StatusCode != test.status {
+				t.Errorf("status %d, expected %d", response.StatusCode, test.status)
+			}
+			var body strings.Builder
+			bufio.NewReader(response.Body).WriteTo(&body)
+			expected, _, _ := GetErrorSite(Errors(test.status), response.Request, &virtualHost{}, "")
+			if body.String() != string(*expected) {
+				t.Errorf("body %q, expected error site %q", body.String(), *expected)
+			}
+		})
+	}
+}
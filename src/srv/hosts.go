@@ -0,0 +1,54 @@
+package srv
+
+import (
+	"net"
+	"strings"
+
+	"server/src/config"
+	"server/src/settings"
+)
+
+// virtualHost serves its own cache of config.Host.SitesDir
+type virtualHost struct {
+	config.Host
+	root dir
+}
+
+// virtual hosts by their names, wildcard
+// hosts are stored as "*.example.com"
+var hosts = map[string]*virtualHost{}
+
+// defaultHost serves config.SitesDir for requests no virtual host matches
+var defaultHost = &virtualHost{}
+
+// getHost returns the virtual host for the Host header of a request,
+// exact names are preferred over wildcards, if none matches defaultHost
+func getHost(hostHeader string) *virtualHost {
+	name := hostname(hostHeader)
+	if vhost, ok := hosts[name]; ok {
+		return vhost
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if vhost, ok := hosts["*"+name[i:]]; ok {
+			return vhost
+		}
+	}
+	return defaultHost
+}
+
+// hostname returns the lowercase name of a Host header without port
+func hostname(hostHeader string) string {
+	name := hostHeader
+	if h, _, err := net.SplitHostPort(hostHeader); err == nil {
+		name = h
+	}
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// defaultSite returns the site to serve if no path was specified
+func (vhost *virtualHost) defaultSite() string {
+	if vhost.DefaultSite != "" {
+		return vhost.DefaultSite
+	}
+	return settings.GetSettings().DefaultSite.Get()
+}
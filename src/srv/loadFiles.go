@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"compress/flate"
 	"compress/gzip"
+	"crypto/sha256"
 	"fmt"
 	"io/ioutil"
 	"runtime"
@@ -18,19 +19,30 @@ import (
 	"server/src/settings"
 )
 
-var root dir
-
 func LoadSites() {
-	log.Log("Loading Sites into Cache")
+	defaultHost = loadHost(config.Host{SitesDir: config.GetConfig().SitesDir})
+	hosts = map[string]*virtualHost{}
+	for _, host := range config.GetConfig().Hosts {
+		vhost := loadHost(host)
+		for _, name := range host.Names {
+			hosts[strings.ToLower(name)] = vhost
+		}
+	}
+	runtime.GC()
+}
+
+// loadHost loads the SitesDir of host into its cache
+func loadHost(host config.Host) *virtualHost {
+	log.Log("Loading Sites of", host.SitesDir, "into Cache")
 	start := time.Now()
 	var size uint64
 	var count counter
 	wg := sync.WaitGroup{}
-	root = loadDir(config.GetConfig().SitesDir, &size, &count, &wg)
+	root := loadDir(host.SitesDir, &size, &count, &wg)
 	wg.Wait()
-	log.Log(fmt.Sprintf("All files (%d) loaded in %s  Size:%dMB", count.count, time.Since(start), size/1048576))
+	log.Log(fmt.Sprintf("All files (%d) of %s loaded in %s  Size:%dMB", count.count, host.SitesDir, time.Since(start), size/1048576))
 	log.Debug(fmt.Sprintf("%d raw; %d deflate; %d gzip; %d br", count.count, count.deflatecount, count.gzipcount, count.brcount))
-	runtime.GC()
+	return &virtualHost{Host: host, root: root}
 }
 
 type dir struct {
@@ -48,27 +60,53 @@ type data struct {
 type file struct {
 	data     data
 	mimetype string
+
+	// last modification of the file on disk
+	modTime time.Time
+
+	// hash of the raw data, used for ETags
+	hash string
 }
 
-func (data *data) getSmallest(encodings *map[Encoding]bool) (dat *[]byte, encoding Encoding) {
-	dat = &data.raw
-	var min = len(data.raw)
-	if (*encodings)[Deflate] && data.deflate != nil && len(data.deflate) < min {
-		min = len(data.deflate)
-		dat = &data.deflate
-		encoding = Deflate
+// getSmallest returns the data in the encoding accept prefers most,
+// on equal preference the smallest one
+//
+// ok is false if no encoding is acceptable
+func (data *data) getSmallest(accept acceptEncoding) (dat *[]byte, encoding Encoding, ok bool) {
+	candidates := []struct {
+		data     *[]byte
+		encoding Encoding
+	}{
+		{&data.raw, ""},
+		{&data.deflate, Deflate},
+		{&data.gzip, GZip},
+		{&data.br, Brotli},
 	}
-	if (*encodings)[GZip] && data.gzip != nil && len(data.gzip) < min {
-		min = len(data.gzip)
-		dat = &data.gzip
-		encoding = GZip
+	var best float64
+	for _, candidate := range candidates {
+		if *candidate.data == nil && candidate.encoding != "" {
+			continue
+		}
+		q := accept.quality(candidate.encoding)
+		if q <= 0 {
+			continue
+		}
+		if q > best || (q == best && len(*candidate.data) < len(*dat)) {
+			best = q
+			dat = candidate.data
+			encoding = candidate.encoding
+		}
 	}
-	if (*encodings)[Brotli] && data.br != nil && len(data.br) < min {
-		min = len(data.br)
-		dat = &data.br
-		encoding = Brotli
+	return dat, encoding, dat != nil
+}
+
+// etag returns the strong ETag of the file compressed
+// with encoding, every encoding has its own ETag
+func (file *file) etag(encoding Encoding) string {
+	if encoding == "" {
+		return fmt.Sprintf("\"%s\"", file.hash)
 	}
-	return
+	return fmt.Sprintf("\"%s-%s\"", file.hash, encoding)
 }
 
 func (file *file) getSize() (size uint64) {
@@ -114,7 +152,7 @@ func loadDir(path string, size *uint64, count *counter, wg *sync.WaitGroup) dir
 				if err != nil {
 					log.Err(err, fmt.Sprintf("Error loading site %s/%s", path, site.Name()))
 				} else {
-					file := createFile(tmpSite, site.Name(), count)
+					file := createFile(tmpSite, site.Name(), site.ModTime(), count)
 					*size += file.getSize()
 					dir.files[site.Name()] = file
 				}
@@ -124,7 +162,7 @@ func loadDir(path string, size *uint64, count *counter, wg *sync.WaitGroup) dir
 	return dir
 }
 
-func createFile(raw []byte, name string, count *counter) *file {
+func createFile(raw []byte, name string, modTime time.Time, count *counter) *file {
 	file := file{
 		data: data{
 			raw:     raw,
@@ -133,6 +171,8 @@ func createFile(raw []byte, name string, count *counter) *file {
 			br:      nil,
 		},
 		mimetype: "",
+		modTime:  modTime,
+		hash:     fmt.Sprintf("%x", sha256.Sum256(raw))[:32],
 	}
 	count.count++
 
@@ -7,10 +7,10 @@ import (
 	"server/src/log"
 )
 
-func LogAccess(code int, duration int, searchDuration int, error error, writeErr error, method string, uri string, encoding Encoding) {
+func LogAccess(code int, duration int, searchDuration int, error error, writeErr error, method string, uri string, encoding Encoding, redirect string, requestID string, upstream string, upstreamLatency int, user string) {
 	//language=SQL
 	query := src.Session.Query(
-		"INSERT INTO server.access (id, uri, code, duration, searchDuration, method, error, writeErr, encoding) VALUES (?,?,?,?,?,?,?,?,?)",
+		"INSERT INTO server.access (id, uri, code, duration, searchDuration, method, error, writeErr, encoding, redirect, requestID, upstream, upstreamLatency, username) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?)",
 		gocql.TimeUUID(), uri, code, duration, searchDuration, method, (func() any {
 			if error != nil {
 				return error.Error()
@@ -23,16 +23,39 @@ func LogAccess(code int, duration int, searchDuration int, error error, writeErr
 			} else {
 				return nil
 			}
-		})(), encoding)
+		})(), encoding, (func() any {
+			if redirect != "" {
+				return redirect
+			} else {
+				return nil
+			}
+		})(), requestID, (func() any {
+			if upstream != "" {
+				return upstream
+			} else {
+				return nil
+			}
+		})(), (func() any {
+			if upstream != "" {
+				return upstreamLatency
+			} else {
+				return nil
+			}
+		})(), (func() any {
+			if user != "" {
+				return user
+			} else {
+				return nil
+			}
+		})())
 	err := query.Exec()
 	if err != nil {
 		log.Err(err, "Error inserting access into DB")
 		log.Debug(query.Context())
 	}
-	log.Debug("LogAccess", uri, code, duration, searchDuration, method, error, writeErr, encoding)
+	log.Debug("LogAccess", uri, code, duration, searchDuration, method, error, writeErr, encoding, redirect, requestID, upstream, upstreamLatency, user)
 }
 
-/*
 func LogAPIAccess(duration int, error error, request string) {
 	//language=SQL
 	query := src.Session.Query(
@@ -51,4 +74,3 @@ func LogAPIAccess(duration int, error error, request string) {
 	}
 	log.Debug("LogAPIAccess", duration, error, request)
 }
-*/
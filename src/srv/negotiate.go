@@ -0,0 +1,51 @@
+package srv
+
+import "strings"
+
+// preferredType returns the media type of offers the Accept headers
+// rate highest, on equal rating the earlier offer wins
+//
+// exact media ranges are preferred over "type/*" and "*/*",
+// if no offer is acceptable the first offer is returned
+func preferredType(values []string, offers []string) string {
+	best, bestQ := offers[0], 0.0
+	for _, offer := range offers {
+		if q := quality(values, offer); q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// quality returns the qvalue of mediaType inside the Accept headers,
+// without Accept headers everything gets 1
+func quality(values []string, mediaType string) float64 {
+	if len(values) == 0 {
+		return 1
+	}
+	mainType, _, _ := strings.Cut(mediaType, "/")
+	q, specificity := 0.0, -1
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			mediaRange, params, _ := strings.Cut(element, ";")
+			mediaRange = strings.ToLower(strings.TrimSpace(mediaRange))
+			rangeQ, ok := parseQValue(params)
+			if !ok {
+				continue
+			}
+			s := -1
+			switch mediaRange {
+			case mediaType:
+				s = 2
+			case mainType + "/*":
+				s = 1
+			case "*/*":
+				s = 0
+			}
+			if s > specificity {
+				q, specificity = rangeQ, s
+			}
+		}
+	}
+	return q
+}
@@ -0,0 +1,56 @@
+package srv
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// canonicalize replaces the path of request with its canonical path,
+// which all rules and the lookup of sites use
+//
+// GET and HEAD requests of non-canonical paths get redirected
+// to the canonical URL instead, the code and location of the
+// redirect are returned, errors for paths which can't be canonicalized
+func canonicalize(request *http.Request, header http.Header) (int, string, error) {
+	canonical, err := canonicalPath(request.URL.Path)
+	if err != nil {
+		return 0, "", err
+	}
+	if canonical != request.URL.Path && (request.Method == http.MethodGet || request.Method == http.MethodHead) {
+		location := (&url.URL{Path: canonical}).EscapedPath()
+		if request.URL.RawQuery != "" {
+			location += "?" + request.URL.RawQuery
+		}
+		header.Set("Location", location)
+		return http.StatusMovedPermanently, location, nil
+	}
+	request.URL.Path, request.URL.RawPath = canonical, ""
+	return 0, "", nil
+}
+
+// canonicalPath returns the canonical form of the decoded path p,
+// paths with NUL bytes or invalid UTF-8 are rejected
+func canonicalPath(p string) (string, error) {
+	if strings.IndexByte(p, 0) >= 0 {
+		return "", errors.New(fmt.Sprintf("path contains NUL byte: %q", p))
+	}
+	if !utf8.ValidString(p) {
+		return "", errors.New(fmt.Sprintf("path is invalid UTF-8: %q", p))
+	}
+	return cleanPath(p), nil
+}
+
+// cleanPath removes "." and ".." segments and duplicate
+// slashes from p, a trailing slash is kept
+func cleanPath(p string) string {
+	cleaned := path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
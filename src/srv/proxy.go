@@ -0,0 +1,269 @@
+package srv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"server/src/config"
+	"server/src/log"
+	"server/src/settings"
+)
+
+// route forwards requests to its upstreams
+type route struct {
+	config.Proxy
+	upstreams []*upstream
+
+	// counter for round-robin
+	next uint32
+
+	proxy *httputil.ReverseProxy
+}
+
+type upstream struct {
+	url *url.URL
+
+	// 1 if the last health check succeeded, accessed atomically
+	healthy int32
+
+	// count of running requests, accessed atomically
+	active int64
+}
+
+// proxyResult of a forwarded request, filled by the proxy
+type proxyResult struct {
+	upstream *upstream
+	code     int
+	err      error
+
+	// start of the forwarded request and time until
+	// the response headers of the upstream arrived
+	start   time.Time
+	latency time.Duration
+
+	// request as received and its host, for error sites
+	request *http.Request
+	vhost   *virtualHost
+}
+
+type proxyResultKey struct{}
+
+// all routes of config.Proxies
+var routes []*route
+
+// LoadProxies creates the routes of config.Proxies
+// and starts their health checks
+func LoadProxies() error {
+	routes = nil
+	for _, proxy := range config.GetConfig().Proxies {
+		if proxy.Balancing != "round-robin" && proxy.Balancing != "least-connections" {
+			return fmt.Errorf("invalid balancing %q of proxy %s", proxy.Balancing, proxy.Path)
+		}
+		if len(proxy.Upstreams) == 0 {
+			return fmt.Errorf("proxy %s has no upstreams", proxy.Path)
+		}
+		r := &route{Proxy: proxy}
+		for _, address := range proxy.Upstreams {
+			u, err := url.Parse(address)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("invalid upstream %q of proxy %s", address, proxy.Path)
+			}
+			r.upstreams = append(r.upstreams, &upstream{url: u, healthy: 1})
+		}
+		r.proxy = r.createReverseProxy()
+		routes = append(routes, r)
+		log.Log(fmt.Sprintf("Proxying %s to %v with %s", proxy.Path, proxy.Upstreams, proxy.Balancing))
+
+		if proxy.HealthCheck.Interval != 0 {
+			go r.healthCheck()
+		}
+	}
+	return nil
+}
+
+// getRoute returns the first route with a path prefix matching path
+func getRoute(path string) *route {
+	for _, r := range routes {
+		if settings.HasPathPrefix(path, r.Path) {
+			return r
+		}
+	}
+	return nil
+}
+
+// serve forwards request to an upstream of route, forbidden
+// paths get 403, errors are answered with 502 or 504 error sites
+func (route *route) serve(w http.ResponseWriter, request *http.Request, vhost *virtualHost) *proxyResult {
+	result := &proxyResult{request: request, vhost: vhost}
+	if additional, err := getForbidden(request.URL.Path); err != nil {
+		result.err = err
+		result.code = writeErrorSite(w, request, vhost, http.StatusForbidden, additional)
+		return result
+	}
+
+	result.upstream = route.pick()
+	if result.upstream == nil {
+		result.err = errors.New(fmt.Sprintf("no healthy upstream for %s", route.Path))
+		result.code = writeErrorSite(w, request, vhost, http.StatusBadGateway, "no healthy upstream")
+		return result
+	}
+
+	atomic.AddInt64(&result.upstream.active, 1)
+	defer atomic.AddInt64(&result.upstream.active, -1)
+
+	ctx := context.WithValue(request.Context(), proxyResultKey{}, result)
+	result.start = time.Now()
+	route.proxy.ServeHTTP(w, request.WithContext(ctx))
+	return result
+}
+
+// pick returns the upstream for the next request,
+// nil if no upstream is healthy
+func (route *route) pick() *upstream {
+	var healthy []*upstream
+	for _, u := range route.upstreams {
+		if atomic.LoadInt32(&u.healthy) == 1 {
+			healthy = append(healthy, u)
+		}
+	}
+	if len(healthy) == 0 {
+		return nil
+	}
+
+	next := healthy[atomic.AddUint32(&route.next, 1)%uint32(len(healthy))]
+	if route.Balancing == "least-connections" {
+		for _, u := range healthy {
+			if atomic.LoadInt64(&u.active) < atomic.LoadInt64(&next.active) {
+				next = u
+			}
+		}
+	}
+	return next
+}
+
+func (route *route) createReverseProxy() *httputil.ReverseProxy {
+	timeout := time.Duration(route.Timeout) * time.Second
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = timeout
+	transport.DialContext = (&net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}).DialContext
+
+	return &httputil.ReverseProxy{
+		Transport: transport,
+		Director: func(request *http.Request) {
+			result := request.Context().Value(proxyResultKey{}).(*proxyResult)
+			target := result.upstream.url
+
+			path := request.URL.Path
+			if route.StripPath {
+				path = "/" + strings.TrimPrefix(strings.TrimPrefix(path, strings.TrimSuffix(route.Path, "/")), "/")
+			}
+			request.URL.Scheme = target.Scheme
+			request.URL.Host = target.Host
+			request.URL.Path = strings.TrimSuffix(target.Path, "/") + path
+			request.URL.RawPath = ""
+
+			// X-Forwarded-For gets set by the ReverseProxy
+			request.Header.Set("X-Forwarded-Host", request.Host)
+			if request.TLS != nil {
+				request.Header.Set("X-Forwarded-Proto", "https")
+			} else {
+				request.Header.Set("X-Forwarded-Proto", "http")
+			}
+			request.Header.Set("X-Request-ID", getRequestID(request))
+			request.Host = target.Host
+			setHeaders(request.Header, route.RequestHeaders)
+		},
+		ModifyResponse: func(response *http.Response) error {
+			result := response.Request.Context().Value(proxyResultKey{}).(*proxyResult)
+			result.code = response.StatusCode
+			result.latency = time.Since(result.start)
+			setHeaders(response.Header, route.ResponseHeaders)
+			applyCorsPolicy(result.request, response.Header)
+			applyHeaderRules(response.Header, result.request.URL.Path, response.Header.Get("Content-Type"), hostname(result.request.Host))
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, request *http.Request, err error) {
+			result := request.Context().Value(proxyResultKey{}).(*proxyResult)
+			result.err = fmt.Errorf("error proxying to %s: %w", result.upstream.url, err)
+			result.latency = time.Since(result.start)
+
+			code := Errors(http.StatusBadGateway)
+			if isTimeout(err) {
+				code = http.StatusGatewayTimeout
+			}
+			result.code = writeErrorSite(w, result.request, result.vhost, code, "")
+		},
+	}
+}
+
+// setHeaders sets headers on header, empty values remove the header
+func setHeaders(header http.Header, headers map[string]string) {
+	for name, value := range headers {
+		if value == "" {
+			header.Del(name)
+		} else {
+			header.Set(name, value)
+		}
+	}
+}
+
+// isTimeout checks if err is caused by a timeout
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// healthCheck checks the upstreams of route every
+// HealthCheck.Interval seconds, blocks forever
+func (route *route) healthCheck() {
+	client := &http.Client{
+		Timeout: time.Duration(route.HealthCheck.Timeout) * time.Second,
+		// redirects count as healthy
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	for ; ; time.Sleep(time.Duration(route.HealthCheck.Interval) * time.Second) {
+		for _, u := range route.upstreams {
+			healthy := int32(0)
+			response, err := client.Get(strings.TrimSuffix(u.url.String(), "/") + route.HealthCheck.Path)
+			if err == nil {
+				if response.StatusCode >= 200 && response.StatusCode < 400 {
+					healthy = 1
+				}
+				response.Body.Close()
+			}
+			if atomic.SwapInt32(&u.healthy, healthy) != healthy {
+				if healthy == 1 {
+					log.Log("Upstream", u.url, "of proxy", route.Path, "is healthy again")
+				} else {
+					log.Err(err, fmt.Sprintf("Upstream %s of proxy %s is unhealthy", u.url, route.Path))
+				}
+			}
+		}
+	}
+}
+
+// writeErrorSite writes the error site for code to w with the CORS
+// headers and header rules of other responses, returns the code
+func writeErrorSite(w http.ResponseWriter, request *http.Request, vhost *virtualHost, code Errors, additional string) int {
+	data, status, mime := GetErrorSite(code, request, vhost, additional)
+	w.Header().Set("Content-Type", mime)
+	applyCorsPolicy(request, w.Header())
+	applyHeaderRules(w.Header(), request.URL.Path, mime, hostname(request.Host))
+	w.WriteHeader(status)
+	if request.Method != http.MethodHead {
+		if _, err := w.Write(*data); err != nil {
+			log.Err(err, "Error writing response:")
+		}
+	}
+	return status
+}
@@ -0,0 +1,168 @@
+package srv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// byteRange of a file, end is inclusive
+type byteRange struct {
+	start int64
+	end   int64
+}
+
+// errUnsatisfiable is returned if no range overlaps the file
+var errUnsatisfiable = errors.New("range not satisfiable")
+
+func (r byteRange) length() int64 {
+	return r.end - r.start + 1
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.end, size)
+}
+
+// parseRange parses a Range header ("bytes=0-99,200-,-50")
+// for a file with size
+//
+// ranges outside of size get dropped, if none are left
+// errUnsatisfiable is returned
+func parseRange(header string, size int64) ([]byteRange, error) {
+	if !strings.HasPrefix(header, "bytes=") {
+		return nil, fmt.Errorf("invalid range unit: %s", header)
+	}
+	spec := strings.TrimPrefix(header, "bytes=")
+
+	var ranges []byteRange
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		first, last, ok := strings.Cut(part, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid range: %s", part)
+		}
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+
+		var r byteRange
+		if first == "" {
+			// suffix range, last bytes of the file
+			suffix, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || suffix < 0 {
+				return nil, fmt.Errorf("invalid range: %s", part)
+			}
+			if suffix == 0 || size == 0 {
+				continue
+			}
+			if suffix > size {
+				suffix = size
+			}
+			r = byteRange{start: size - suffix, end: size - 1}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, fmt.Errorf("invalid range: %s", part)
+			}
+			if start >= size {
+				continue
+			}
+			r = byteRange{start: start, end: size - 1}
+			if last != "" {
+				end, err := strconv.ParseInt(last, 10, 64)
+				if err != nil || end < start {
+					return nil, fmt.Errorf("invalid range: %s", part)
+				}
+				if end < size-1 {
+					r.end = end
+				}
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiable
+	}
+	return ranges, nil
+}
+
+// ifRange checks if the If-Range header of request still matches
+// the file, if not the full file has to be sent
+//
+// ETags are compared strong against the uncompressed file
+func ifRange(request *http.Request, file *file) bool {
+	value := request.Header.Get("If-Range")
+	if value == "" {
+		return true
+	}
+	if strings.HasPrefix(value, "\"") {
+		return value == file.etag("")
+	}
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return false
+	}
+	return !file.modTime.Truncate(time.Second).After(date)
+}
+
+// getRanges returns the requested ranges of the raw file data
+// a single range gets returned directly, multiple as multipart/byteranges
+//
+// if the ranges are invalid or larger than the file itself,
+// nil is returned to send the full file instead
+func (file *file) getRanges(rangeHeader string, header http.Header) (*[]byte, int, string, error) {
+	size := int64(len(file.data.raw))
+	ranges, err := parseRange(rangeHeader, size)
+	if errors.Is(err, errUnsatisfiable) {
+		header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		return nil, http.StatusRequestedRangeNotSatisfiable, "", err
+	}
+	if err != nil {
+		// invalid Range headers get ignored
+		return nil, http.StatusOK, "", nil
+	}
+
+	var sum int64
+	for _, r := range ranges {
+		sum += r.length()
+	}
+	if sum > size {
+		// overlapping ranges are more expensive than the whole file
+		return nil, http.StatusOK, "", nil
+	}
+
+	if len(ranges) == 1 {
+		header.Set("Content-Range", ranges[0].contentRange(size))
+		data := file.data.raw[ranges[0].start : ranges[0].end+1]
+		return &data, http.StatusPartialContent, file.mimetype, nil
+	}
+
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	for _, r := range ranges {
+		partHeader := textproto.MIMEHeader{}
+		if file.mimetype != "" {
+			partHeader.Set("Content-Type", file.mimetype)
+		}
+		partHeader.Set("Content-Range", r.contentRange(size))
+		part, err := writer.CreatePart(partHeader)
+		if err != nil {
+			return nil, http.StatusInternalServerError, "", err
+		}
+		if _, err := part.Write(file.data.raw[r.start : r.end+1]); err != nil {
+			return nil, http.StatusInternalServerError, "", err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, http.StatusInternalServerError, "", err
+	}
+	data := buf.Bytes()
+	return &data, http.StatusPartialContent, "multipart/byteranges; boundary=" + writer.Boundary(), nil
+}
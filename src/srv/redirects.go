@@ -0,0 +1,37 @@
+package srv
+
+import (
+	"net/http"
+	"strings"
+
+	"server/src/settings"
+)
+
+// applyRedirects applies the first settings.Redirects rule matching
+// the path of request, returns the target, "" if no rule matched
+//
+// rewrites change the path of request and return code 0,
+// redirects set the Location header and return their code
+func applyRedirects(request *http.Request, header http.Header) (int, string) {
+	for _, rule := range settings.GetSettings().Redirects.Get() {
+		target, ok := rule.Apply(request.URL.Path)
+		if !ok {
+			continue
+		}
+		if rule.Code == 0 {
+			path, query, hasQuery := strings.Cut(target, "?")
+			request.URL.Path, request.URL.RawPath = cleanPath(path), ""
+			if hasQuery {
+				request.URL.RawQuery = query
+			}
+			return 0, target
+		}
+		// keep the query, if the target has none
+		if request.URL.RawQuery != "" && !strings.Contains(target, "?") {
+			target += "?" + request.URL.RawQuery
+		}
+		header.Set("Location", target)
+		return rule.Code, target
+	}
+	return 0, ""
+}
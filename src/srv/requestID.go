@@ -0,0 +1,32 @@
+package srv
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+
+	"github.com/gocql/gocql"
+)
+
+type requestIDKey struct{}
+
+// valid X-Request-ID headers, other ones get replaced
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// withRequestID returns request with its ID and sets the X-Request-ID
+// header of the response, a valid X-Request-ID of the request is kept,
+// otherwise a new one gets created
+func withRequestID(request *http.Request, header http.Header) *http.Request {
+	id := request.Header.Get("X-Request-ID")
+	if !requestIDPattern.MatchString(id) {
+		id = gocql.TimeUUID().String()
+	}
+	header.Set("X-Request-ID", id)
+	return request.WithContext(context.WithValue(request.Context(), requestIDKey{}, id))
+}
+
+// getRequestID returns the ID of request, "" if it has none
+func getRequestID(request *http.Request) string {
+	id, _ := request.Context().Value(requestIDKey{}).(string)
+	return id
+}
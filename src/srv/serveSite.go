@@ -3,8 +3,8 @@ package srv
 import (
 	"errors"
 	"fmt"
-	"html"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -12,65 +12,129 @@ import (
 	"server/src/settings"
 )
 
-func getSite(request *http.Request, availableEncodings *map[Encoding]bool) (*[]byte, Encoding, int, string, error) {
-	if request.Method != "GET" {
-		data, code := GetErrorSite(http.StatusMethodNotAllowed, request.Host, request.URL.Path, "")
-		return data, "", code, "text/html", errors.New(fmt.Sprintf("not get method (%v)", request.Method))
-	}
+// methods allowed if no AllowedMethods rule matches
+var defaultMethods = []string{http.MethodGet, http.MethodHead, http.MethodOptions}
+
+// getSite returns the site of vhost for request, headers
+// besides the Content-Type get set on header
+func getSite(request *http.Request, vhost *virtualHost, header http.Header, accept acceptEncoding) (*[]byte, Encoding, int, string, error) {
 	if uint16(len(request.URL.String())) > settings.GetSettings().MaxURILength.Get() {
-		data, code := GetErrorSite(http.StatusRequestURITooLong, request.Host, request.URL.Path, "")
-		return data, "", code, "text/html", errors.New(fmt.Sprintf("URI to long (%v)", len(request.URL.String())))
+		data, code, mime := GetErrorSite(http.StatusRequestURITooLong, request, vhost, "")
+		return data, "", code, mime, errors.New(fmt.Sprintf("URI to long (%v)", len(request.URL.String())))
 	}
 
-	url := html.EscapeString(request.URL.Path)
-	host := html.EscapeString(request.Host)
+	// the path is canonical, see canonicalize
+	url := request.URL.Path
 
-	for _, forbidden := range settings.GetSettings().Forbidden.Get() {
-		switch forbidden.Type {
-		case settings.FileExtension:
-			if strings.HasSuffix(url, "."+forbidden.Data) {
-				data, code := GetErrorSite(http.StatusForbidden, host, url, "forbidden by FileExtension")
-				return data, "", code, "text/html", errors.New(fmt.Sprintf("forbidden by extension: %s (%s)", forbidden.Data, url))
+	methods := getAllowedMethods(url)
+
+	// preflights are answered by CreateServe, see answerPreflight
+	applyCorsPolicy(request, header)
+
+	if !contains(methods, request.Method) {
+		header.Set("Allow", strings.Join(methods, ", "))
+		data, code, mime := GetErrorSite(http.StatusMethodNotAllowed, request, vhost, "")
+		return data, "", code, mime, errors.New(fmt.Sprintf("method not allowed (%v)", request.Method))
+	}
+
+	if additional, err := getForbidden(url); err != nil {
+		data, code, mime := GetErrorSite(http.StatusForbidden, request, vhost, additional)
+		return data, "", code, mime, err
+	}
+
+	if request.Method == http.MethodOptions {
+		header.Set("Allow", strings.Join(methods, ", "))
+		empty := []byte{}
+		return &empty, "", http.StatusNoContent, "", nil
+	}
+
+	file, directory, isDir := vhost.root.lookup(url)
+	if isDir {
+		if !strings.HasSuffix(url, "/") {
+			location := request.URL.EscapedPath() + "/"
+			if request.URL.RawQuery != "" {
+				location += "?" + request.URL.RawQuery
 			}
-		case settings.AbsoluteFile:
-			if url == forbidden.Data {
-				data, code := GetErrorSite(http.StatusForbidden, host, url, "forbidden by absolute path")
-				return data, "", code, "text/html", errors.New(fmt.Sprintf("forbidden by absolute path: %s", forbidden.Data))
+			header.Set("Location", location)
+			empty := []byte{}
+			return &empty, "", http.StatusMovedPermanently, "", nil
+		}
+		var index string
+		index, file = directory.index()
+		// the index can be forbidden on its own (e.g. AbsoluteFile /dir/index.html)
+		if file != nil {
+			if additional, err := getForbidden(url + index); err != nil {
+				data, code, mime := GetErrorSite(http.StatusForbidden, request, vhost, additional)
+				return data, "", code, mime, err
 			}
-		case settings.AbsoluteDirectory:
-			if strings.HasPrefix(url, forbidden.Data) {
-				data, code := GetErrorSite(http.StatusForbidden, host, url, "forbidden by absolute DirPath")
-				return data, "", code, "text/html", errors.New(fmt.Sprintf("forbidden by absolute DirPath: %s (%s)", forbidden.Data, url))
+		}
+		if file == nil && autoindexEnabled(url) {
+			header.Add("Vary", "Accept")
+			data, mime, err := getListing(request, directory, url)
+			if err != nil {
+				data, code, mime := GetErrorSite(http.StatusInternalServerError, request, vhost, "")
+				return data, "", code, mime, fmt.Errorf("error creating listing of %s: %w", url, err)
 			}
-		case settings.Regex:
-			if forbidden.Regex.Match([]byte(url)) {
-				data, code := GetErrorSite(http.StatusForbidden, host, url, "forbidden by regex")
-				return data, "", code, "text/html", errors.New(fmt.Sprintf("forbidden by regex: %s (%s)", forbidden.Data, url))
+			return data, "", http.StatusOK, mime, nil
+		}
+	}
+	if file == nil {
+		var document string
+		document, file = vhost.spaFallback(url)
+		if file != nil {
+			if additional, err := getForbidden(document); err != nil {
+				data, code, mime := GetErrorSite(http.StatusForbidden, request, vhost, additional)
+				return data, "", code, mime, err
 			}
 		}
 	}
+	if file == nil && isDir {
+		data, code, mime := GetErrorSite(http.StatusNotFound, request, vhost, "directory has no index file")
+		return data, "", code, mime, errors.New(fmt.Sprintf("no index file in: %s", url))
+	}
+	if file == nil {
+		data, code, mime := GetErrorSite(http.StatusNotFound, request, vhost, "")
+		return data, "", code, mime, errors.New(fmt.Sprintf("no site data for: %s", url))
+	}
 
-	pathSplit := strings.Split(url, "/")[1:]
+	header.Set("Accept-Ranges", "bytes")
+	header.Set("Last-Modified", file.modTime.UTC().Format(http.TimeFormat))
+	header.Add("Vary", "Accept-Encoding")
+	if cacheControl := getCacheControl(url); cacheControl != "" {
+		header.Set("Cache-Control", cacheControl)
+	}
 
-	depth := len(pathSplit)
+	data, encoding, ok := file.data.getSmallest(accept)
+	if !ok {
+		data, code, mime := GetErrorSite(http.StatusNotAcceptable, request, vhost, "no acceptable Content-Encoding")
+		return data, "", code, mime, errors.New(fmt.Sprintf("no acceptable encoding (%s)", request.Header.Get("Accept-Encoding")))
+	}
 
-	dir := root
-	for i := 0; i < depth-1; i++ {
-		dir = dir.dirs[pathSplit[i]]
-		if dir.files == nil {
-			break
-		}
+	// ranges always get served from the uncompressed file
+	rangeHeader := request.Header.Get("Range")
+	useRange := rangeHeader != "" && request.Method == http.MethodGet && accept.quality("") > 0 && ifRange(request, file)
+	if useRange {
+		data, encoding = &file.data.raw, ""
 	}
-	file, ok := dir.files[pathSplit[depth-1]]
-	if !ok {
-		if _, ok := dir.dirs[pathSplit[depth-1]]; ok {
-			data, code := GetErrorSite(http.StatusNotFound, host, url, fmt.Sprintf("%s is no file, but a directory", pathSplit[depth-1]))
-			return data, "", code, "text/html", errors.New(fmt.Sprintf("no site data for: %v", pathSplit))
+
+	etag := file.etag(encoding)
+	header.Set("ETag", etag)
+	if notModified(request, etag, file.modTime) {
+		empty := []byte{}
+		return &empty, "", http.StatusNotModified, "", nil
+	}
+
+	if useRange {
+		data, code, mime, err := file.getRanges(rangeHeader, header)
+		if err != nil {
+			data, code, mime := GetErrorSite(Errors(code), request, vhost, "")
+			return data, "", code, mime, fmt.Errorf("error getting ranges %s: %w", rangeHeader, err)
+		}
+		if data != nil {
+			return data, "", code, mime, nil
 		}
-		data, code := GetErrorSite(http.StatusNotFound, host, url, "")
-		return data, "", code, "text/html", errors.New(fmt.Sprintf("no site data for: %s", pathSplit))
 	}
-	data, encoding := file.data.getSmallest(availableEncodings)
+
 	return data, encoding, 200, file.mimetype, nil
 }
 
@@ -79,47 +143,243 @@ func getSite(request *http.Request, availableEncodings *map[Encoding]bool) (*[]b
 // Registers a handle for '/' to serve the DefaultSite
 func CreateServe() http.HandlerFunc {
 	fun := func(w http.ResponseWriter, r *http.Request) {
+		r = withRequestID(r, w.Header())
+		vhost := getHost(r.Host)
+
+		if !requests.add() {
+			data, code, mime := GetErrorSite(http.StatusServiceUnavailable, r, vhost, "server is shutting down")
+			w.Header().Set("Connection", "close")
+			w.Header().Set("Content-Type", mime)
+			w.WriteHeader(code)
+			if _, err := w.Write(*data); err != nil {
+				log.Err(err, "Error writing response:")
+			}
+			return
+		}
+		defer requests.done()
+
 		if settings.GetSettings().ServerOff.Get() {
 			w.WriteHeader(http.StatusGone)
 		}
 		start := time.Now()
-		if r.URL.Path == "/" {
-			r.URL.Path = settings.GetSettings().DefaultSite.Get()
-		}
-		encodings := strings.Split(r.Header.Get("Accept-Encoding"), ",")
-		availableEncodings := map[Encoding]bool{
-			Deflate: false,
-			GZip:    false,
-			Brotli:  false,
+		uri := r.URL.Path
+
+		var (
+			msg      *[]byte
+			encoding Encoding
+			code     int
+			mime     string
+			err      error
+		)
+		redirectCode, redirect, pathErr := canonicalize(r, w.Header())
+		if pathErr == nil && redirectCode == 0 {
+			redirectCode, redirect = applyRedirects(r, w.Header())
 		}
-		for _, encoding := range encodings {
-			availableEncodings[Encoding(strings.TrimSpace(encoding))] = true
+		// CORS preflights don't carry credentials, so preflights of paths
+		// with a CORS policy get answered before authentication and routing,
+		// realms match the rewritten path
+		var authCode Errors
+		preflight := false
+		if pathErr == nil && redirectCode == 0 {
+			if preflight = answerPreflight(r, w.Header()); !preflight {
+				r, authCode, err = authenticate(r, w.Header())
+			}
 		}
+		if pathErr != nil {
+			msg, code, mime = GetErrorSite(http.StatusBadRequest, r, vhost, "invalid path")
+			err = pathErr
+		} else if redirectCode != 0 {
+			empty := []byte{}
+			msg, code = &empty, redirectCode
+		} else if preflight {
+			empty := []byte{}
+			msg, code = &empty, http.StatusNoContent
+		} else if authCode != 0 {
+			msg, code, mime = GetErrorSite(authCode, r, vhost, "")
+		} else if route := getRoute(r.URL.Path); route != nil {
+			result := route.serve(w, r, vhost)
+			if result.err != nil {
+				log.Err(result.err, fmt.Sprintf("Error proxying %s", r.URL.Path))
+			}
+			upstream := ""
+			if result.upstream != nil {
+				upstream = result.upstream.url.String()
+			}
+			logAccess(r, uri, result.code, start, time.Now(), result.err, nil, "", redirect, upstream, result.latency)
+			return
+		} else if gateway, script := getGateway(r.URL.Path); gateway != nil {
+			result := gateway.serve(w, r, vhost, script)
+			if result.err != nil {
+				log.Err(result.err, fmt.Sprintf("Error running %s", r.URL.Path))
+			}
+			logAccess(r, uri, result.code, start, time.Now(), result.err, nil, "", redirect, gateway.Address, result.latency)
+			return
+		} else {
+			if r.URL.Path == "/" {
+				r.URL.Path = cleanPath(vhost.defaultSite())
+				// the DefaultSite can be inside a realm "/" is not in
+				r, authCode, err = authenticate(r, w.Header())
+			}
+			if authCode != 0 {
+				msg, code, mime = GetErrorSite(authCode, r, vhost, "")
+			} else {
+				accept := parseAcceptEncoding(r.Header.Values("Accept-Encoding"))
 
-		msg, encoding, code, mime, err := getSite(r, &availableEncodings)
+				msg, encoding, code, mime, err = getSite(r, vhost, w.Header(), accept)
+			}
+		}
 
 		searchTime := time.Now()
 
 		if err != nil {
 			log.Err(err, fmt.Sprintf("Error getting site %s", r.URL.Path))
-			w.WriteHeader(code)
-		} else {
+		} else if encoding != "" {
+			w.Header().Set("Content-encoding", string(encoding))
+		}
+		// error sites have a Content-Type too (e.g. NotFound of hosts)
+		if mime != "" {
 			w.Header().Set("Content-Type", mime)
-			if encoding != "" {
-				w.Header().Set("Content-encoding", string(encoding))
-			}
 		}
+		applyHeaderRules(w.Header(), r.URL.Path, mime, hostname(r.Host))
+		bodyless := code == http.StatusNoContent || code == http.StatusNotModified
+		if !bodyless {
+			w.Header().Set("Content-Length", strconv.Itoa(len(*msg)))
+		}
+		w.WriteHeader(code)
 
-		_, er := w.Write(*msg)
-		if er != nil {
-			log.Err(er, "Error writing response:")
+		var er error
+		// HEAD gets the same headers as GET, but no body
+		if r.Method != http.MethodHead && !bodyless {
+			_, er = w.Write(*msg)
+			if er != nil {
+				log.Err(er, "Error writing response:")
+			}
 		}
-		go LogAccess(code, int(time.Since(start).Microseconds()), int(searchTime.Sub(start).Microseconds()), err, er, r.Method, r.URL.Path, encoding)
+		logAccess(r, uri, code, start, searchTime, err, er, encoding, redirect, "", 0)
 	}
 
 	return fun
 }
 
+// logAccess writes the access log of request async,
+// logs are dropped after they got flushed on shutdown
+func logAccess(request *http.Request, uri string, code int, start time.Time, searchTime time.Time, err error, writeErr error, encoding Encoding, redirect string, upstream string, upstreamLatency time.Duration) {
+	duration := time.Since(start)
+	if !pendingLogs.add() {
+		log.Err(nil, "Access logs already flushed, dropping access log for", uri)
+		return
+	}
+	go func() {
+		defer pendingLogs.done()
+		LogAccess(code, int(duration.Microseconds()), int(searchTime.Sub(start).Microseconds()), err, writeErr, request.Method, uri, encoding, redirect, getRequestID(request), upstream, int(upstreamLatency.Microseconds()), getUser(request))
+	}()
+}
+
+// lookup returns the file at path inside root, or if path is a
+// directory the directory with isDir set, file is nil if nothing exists
+func (root dir) lookup(path string) (file *file, directory dir, isDir bool) {
+	directory = root
+	trimmed := strings.Trim(path, "/")
+	if trimmed == "" {
+		return nil, directory, true
+	}
+	parts := strings.Split(trimmed, "/")
+	for _, part := range parts[:len(parts)-1] {
+		var ok bool
+		if directory, ok = directory.dirs[part]; !ok {
+			return nil, dir{}, false
+		}
+	}
+	name := parts[len(parts)-1]
+	if file, ok := directory.files[name]; ok && !strings.HasSuffix(path, "/") {
+		return file, dir{}, false
+	}
+	if directory, ok := directory.dirs[name]; ok {
+		return nil, directory, true
+	}
+	return nil, dir{}, false
+}
+
+// index returns the name and file of the first settings.IndexFiles
+// inside directory, nil if none exists
+func (directory dir) index() (string, *file) {
+	for _, name := range settings.GetSettings().IndexFiles.Get() {
+		if file, ok := directory.files[name]; ok {
+			return name, file
+		}
+	}
+	return "", nil
+}
+
+// getForbidden returns the description and error of the
+// first Forbidden rule matching url, nil if none matches
+func getForbidden(url string) (string, error) {
+	for _, forbidden := range settings.GetSettings().Forbidden.Get() {
+		if forbidden.Matches(url) {
+			description := forbiddenDescriptions[forbidden.Type]
+			return description, fmt.Errorf("%s: %s (%s)", description, forbidden.Data, url)
+		}
+	}
+	return "", nil
+}
+
+// descriptions of the Forbidden types shown on error sites
+var forbiddenDescriptions = map[settings.ForbiddenType]string{
+	settings.FileExtension:     "forbidden by FileExtension",
+	settings.AbsoluteFile:      "forbidden by absolute path",
+	settings.AbsoluteDirectory: "forbidden by absolute DirPath",
+	settings.Regex:             "forbidden by regex",
+}
+
+// getAllowedMethods returns the methods of the first
+// AllowedMethods rule matching url
+func getAllowedMethods(url string) []string {
+	for _, rule := range settings.GetSettings().AllowedMethods.Get() {
+		if settings.HasPathPrefix(url, rule.Path) {
+			return rule.Methods
+		}
+	}
+	return defaultMethods
+}
+
+// applyHeaderRules applies all settings.HeaderRules matching
+// a response of mimetype for path requested from host to header
+func applyHeaderRules(header http.Header, path, mimetype, host string) {
+	for _, rule := range settings.GetSettings().HeaderRules.Get() {
+		if !rule.Matches(path, mimetype, host) {
+			continue
+		}
+		switch rule.Action {
+		case settings.HeaderSet:
+			header.Set(rule.Name, rule.Value)
+		case settings.HeaderAppend:
+			header.Add(rule.Name, rule.Value)
+		case settings.HeaderRemove:
+			header.Del(rule.Name)
+		}
+	}
+}
+
+// getCacheControl returns the Cache-Control header of
+// the first CacheRule matching url, "" if none matches
+func getCacheControl(url string) string {
+	for _, rule := range settings.GetSettings().CacheRules.Get() {
+		if rule.Matches(url) {
+			return rule.CacheControl()
+		}
+	}
+	return ""
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
 type Encoding string
 
 const (
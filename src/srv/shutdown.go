@@ -0,0 +1,88 @@
+package srv
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// tracker counts running tasks, like requests or access logs,
+// so they can be waited for on shutdown
+//
+// after close no new tasks get accepted
+type tracker struct {
+	mutex   sync.Mutex
+	closed  bool
+	count   uint64
+	drained chan struct{}
+}
+
+// requests currently getting served
+var requests = newTracker()
+
+// access logs currently getting written to the DB
+var pendingLogs = newTracker()
+
+func newTracker() *tracker {
+	return &tracker{drained: make(chan struct{})}
+}
+
+// add a task, returns false if tracker is already closed
+func (t *tracker) add() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.closed {
+		return false
+	}
+	t.count++
+	return true
+}
+
+func (t *tracker) done() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.count--
+	if t.closed && t.count == 0 {
+		close(t.drained)
+	}
+}
+
+func (t *tracker) close() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.closed {
+		return
+	}
+	t.closed = true
+	if t.count == 0 {
+		close(t.drained)
+	}
+}
+
+// wait for all tasks to finish or the ctx to expire
+func (t *tracker) wait(ctx context.Context) error {
+	select {
+	case <-t.drained:
+		return nil
+	case <-ctx.Done():
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		return fmt.Errorf("%d still running: %w", t.count, ctx.Err())
+	}
+}
+
+// Shutdown stops serving new requests, waits for the running
+// requests to finish and flushes the pending access logs afterwards
+//
+// blocks until done or ctx expires
+func Shutdown(ctx context.Context) error {
+	requests.close()
+	if err := requests.wait(ctx); err != nil {
+		return fmt.Errorf("error waiting for requests: %w", err)
+	}
+	pendingLogs.close()
+	if err := pendingLogs.wait(ctx); err != nil {
+		return fmt.Errorf("error flushing access logs: %w", err)
+	}
+	return nil
+}
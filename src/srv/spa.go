@@ -0,0 +1,24 @@
+package srv
+
+import (
+	"strings"
+
+	"server/src/settings"
+)
+
+// spaFallback returns the path and file of the document of the first
+// settings.SpaFallbacks fallback with a path prefix matching url, nil if
+// no fallback matches, the document doesn't exist or url looks like an asset
+func (vhost *virtualHost) spaFallback(url string) (string, *file) {
+	// assets have an extension in their last segment
+	if strings.Contains(url[strings.LastIndex(url, "/")+1:], ".") {
+		return "", nil
+	}
+	for _, fallback := range settings.GetSettings().SpaFallbacks.Get() {
+		if settings.HasPathPrefix(url, fallback.Path) {
+			file, _, _ := vhost.root.lookup(fallback.Document)
+			return fallback.Document, file
+		}
+	}
+	return "", nil
+}